@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"sync"
 	"time"
 
@@ -44,9 +43,17 @@ func sendValueOverChannel() {
 	sumChan := make(chan int)
 	defer close(sumChan)
 
+	// Each Goroutine gets its own generator, forked from the default one here
+	// in the main Goroutine. Forking always happens in the same order, so with
+	// the same seed each Goroutine draws the same numbers no matter how they
+	// get scheduled, and they don't fight over a shared lock.
+	rng := utils.DefaultRandomGenerator.Fork()
+
 	go func() {
-		// In this case, we execute our sum
-		sumChan <- utils.Sum(utils.GenerateRandomNumbers(0, 100, 100))
+		// In this case, we execute our sum. We know that 100 numbers between 0
+		// and 100 cannot overflow an int, so we ignore the error here.
+		sum, _ := utils.Sum(rng.Ints(0, 100, 100))
+		sumChan <- sum
 	}()
 
 	// This syntax will block the current Goroutine until a value is published
@@ -69,11 +76,12 @@ func iteratingOverChannels() {
 
 	// Start a Goroutine that generates random numbers and sends them iteratively
 	// to the number channel. It will close the channel when it is finished.
+	rng := utils.DefaultRandomGenerator.Fork()
 	go func() {
 		n := 100
 
 		for i := 0; i <= n; i++ {
-			numChan <- utils.GenerateRandomNumber(0, 100)
+			numChan <- rng.Int(0, 100)
 		}
 
 		// Close our channel when we've generated all of our numbers.
@@ -117,9 +125,9 @@ func multipleGoroutinesReadingAndWritingToTheSameChannel() {
 
 	// This producer function will produce 100 random numbers and send them over
 	// the common channel.
-	producerFunc := func() {
+	producerFunc := func(rng *utils.RandomGenerator) {
 		for i := 0; i < 10; i++ {
-			num := utils.GenerateRandomNumber(0, 100)
+			num := rng.Int(0, 100)
 			fmt.Printf("sent %d from producer Goroutine %d\n", num, utils.GetGoroutineID())
 			numChan <- num
 		}
@@ -144,9 +152,10 @@ func multipleGoroutinesReadingAndWritingToTheSameChannel() {
 	producerWaitGroup := sync.WaitGroup{}
 	for i := 1; i <= 5; i++ {
 		producerWaitGroup.Add(1)
+		rng := utils.DefaultRandomGenerator.Fork()
 		go func() {
 			defer producerWaitGroup.Done()
-			producerFunc()
+			producerFunc(rng)
 		}()
 	}
 
@@ -185,9 +194,9 @@ func nonBlockingChannelReads() {
 
 	// This function accepts a channel as an argument for where to send values
 	// to. It also accepts an ID, which is solely for identification reasons.
-	sendRandomNumbersToChannel := func(destChan chan int, id int) {
-		for _, num := range utils.GenerateRandomNumbers(0, 100, 100) {
-			// time.Sleep(time.Millisecond * time.Duration(utils.GenerateRandomNumber(0, 100)))
+	sendRandomNumbersToChannel := func(destChan chan int, id int, rng *utils.RandomGenerator) {
+		for _, num := range rng.Ints(0, 100, 100) {
+			// time.Sleep(time.Millisecond * time.Duration(rng.Int(0, 100)))
 			destChan <- num
 		}
 		close(destChan) // Comment this line out and see what happens :).
@@ -196,9 +205,9 @@ func nonBlockingChannelReads() {
 
 	// Start Goroutines to sum numbers and send the results to the provided
 	// channel upon completion.
-	go sendRandomNumbersToChannel(chan1, 1)
-	go sendRandomNumbersToChannel(chan2, 2)
-	go sendRandomNumbersToChannel(chan3, 3)
+	go sendRandomNumbersToChannel(chan1, 1, utils.DefaultRandomGenerator.Fork())
+	go sendRandomNumbersToChannel(chan2, 2, utils.DefaultRandomGenerator.Fork())
+	go sendRandomNumbersToChannel(chan3, 3, utils.DefaultRandomGenerator.Fork())
 
 	go func() {
 		// We must keep track of which channel has been read so we know whether we
@@ -310,7 +319,11 @@ func shuttingDownAGoroutine() {
 }
 
 func main() {
-	rand.Seed(time.Now().Unix())
+	// The random numbers come from a generator that is seeded from the clock
+	// unless UTILS_RANDOM_SEED is set. Running again with
+	// UTILS_RANDOM_SEED=<this seed> makes every Goroutine draw the same
+	// numbers, although the order in which they print may still differ.
+	fmt.Println("Random seed:", utils.DefaultRandomGenerator.Seed())
 
 	waitingWithAChannel()
 	sendValueOverChannel()
@@ -153,8 +153,12 @@ func sharedContexts() {
 // have a shorter timeout for certain things.
 func childContexts(parentTimeout time.Duration) {
 	fmt.Printf("Parent context has timeout %s\n", parentTimeout)
-	parentCtx, parentCancel := context.WithTimeout(context.Background(), parentTimeout)
-	defer parentCancel()
+	// We use utils.WithTimeoutCause instead of context.WithTimeout so that each
+	// child can find out whether it was its own timeout or its parent's that
+	// shut it down. ctx.Err() alone would say "context deadline exceeded"
+	// either way.
+	parentCtx, parentCancel := utils.WithTimeoutCause(context.Background(), "parent-context", parentTimeout, nil)
+	defer parentCancel(nil)
 
 	wg := sync.WaitGroup{}
 
@@ -166,10 +170,12 @@ func childContexts(parentTimeout time.Duration) {
 			defer wg.Done()
 			// Create a child context from our parent context with an incrementally-increasing timeout.
 			childTimeout := time.Millisecond * time.Duration(i*5)
-			childCtx, childCancel := context.WithTimeout(parentCtx, childTimeout)
-			defer childCancel()
+			name := fmt.Sprintf("child-context-%d", i)
+			childCtx, childCancel := utils.WithTimeoutCause(parentCtx, name, childTimeout, nil)
+			defer childCancel(nil)
 
-			<-startLongRunningProcess(childCtx, fmt.Sprintf("child-context-%d", i))
+			<-startLongRunningProcess(childCtx, name)
+			fmt.Printf("%s stopped because: %s\n", name, utils.Cause(childCtx))
 		}()
 	}
 
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Configures RunBatcher.
+type BatcherOptions struct {
+	// Flush once a batch has this many items. Defaults to 100.
+	MaxSize int
+	// Flush once this long has passed since the first item of a batch arrived,
+	// however few items it has. Defaults to 1s.
+	MaxWait time.Duration
+	// How many flushes may run at once. Once this many are running, we stop
+	// reading from the input until one finishes. Defaults to 1.
+	MaxConcurrentFlushes int
+	// If set, called with each failed flush as soon as it fails, for when
+	// waiting for RunBatcher to return is too long to go without knowing.
+	OnFlushError func(err *BatchError)
+}
+
+// Describes a flush that failed.
+type BatchError struct {
+	// The batch's position in the stream, starting at 0.
+	Batch int
+	Size  int
+	Err   error
+}
+
+func (b *BatchError) Error() string {
+	return fmt.Sprintf("flushing batch %d (%d items): %s", b.Batch, b.Size, b.Err)
+}
+
+func (b *BatchError) Unwrap() error {
+	return b.Err
+}
+
+// Reads values from in and hands them to flush in batches, for when a bulk
+// write is much cheaper than one write per value. A batch is flushed once it
+// is full or once MaxWait has passed since its first value, whichever comes
+// first. Unlike the Batch pipeline stage, a slow trickle of values doesn't
+// leave a partial batch waiting indefinitely.
+//
+// It returns once in is closed or the context ends, after flushing whatever
+// is left and waiting for every flush to finish. Flushes get a context that
+// carries ctx's values but is not cancelled with it, so that the final flush
+// still has a chance to succeed. Anything still in the input channel when the
+// context ends is not read.
+//
+// Returns a *MultiError of *BatchErrors if any flushes failed, otherwise the
+// context's error if it ended early, otherwise nil.
+func RunBatcher[T any](ctx context.Context, in <-chan T, opts BatcherOptions, flush func(ctx context.Context, batch []T) error) error {
+	if opts.MaxSize < 1 {
+		opts.MaxSize = 100
+	}
+
+	if opts.MaxWait == 0 {
+		opts.MaxWait = time.Second
+	}
+
+	if opts.MaxConcurrentFlushes < 1 {
+		opts.MaxConcurrentFlushes = 1
+	}
+
+	flushCtx := detachedContext{parent: ctx}
+	sem := NewSemaphore(int64(opts.MaxConcurrentFlushes))
+	wg := sync.WaitGroup{}
+
+	mux := sync.Mutex{}
+	errs := []*BatchError{}
+
+	batch := make([]T, 0, opts.MaxSize)
+	batchNum := 0
+
+	var timer *time.Timer
+	var timerChan <-chan time.Time
+
+	flushBatch := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			timerChan = nil
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		toFlush := batch
+		num := batchNum
+		batch = make([]T, 0, opts.MaxSize)
+		batchNum++
+
+		// This can't fail since the context is never cancelled.
+		sem.Acquire(flushCtx, 1)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+
+			err := callRecovered(func() error {
+				return flush(flushCtx, toFlush)
+			})
+			if err == nil {
+				return
+			}
+
+			batchErr := &BatchError{Batch: num, Size: len(toFlush), Err: err}
+
+			mux.Lock()
+			errs = append(errs, batchErr)
+			mux.Unlock()
+
+			if opts.OnFlushError != nil {
+				opts.OnFlushError(batchErr)
+			}
+		}()
+	}
+
+loop:
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				break loop
+			}
+
+			batch = append(batch, v)
+
+			if len(batch) >= opts.MaxSize {
+				flushBatch()
+			} else if len(batch) == 1 {
+				timer = time.NewTimer(opts.MaxWait)
+				timerChan = timer.C
+			}
+		case <-timerChan:
+			flushBatch()
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	flushBatch()
+	wg.Wait()
+
+	if len(errs) > 0 {
+		// Flushes can fail out of order when more than one runs at once.
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Batch < errs[j].Batch
+		})
+
+		wrapped := make([]error, len(errs))
+		for i, err := range errs {
+			wrapped[i] = err
+		}
+
+		return NewMultiError(wrapped...)
+	}
+
+	return ctx.Err()
+}
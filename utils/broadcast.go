@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// Returned by Broadcaster.Publish once the Broadcaster has been closed.
+	ErrBroadcasterClosed = errors.New("broadcaster is closed")
+	// Returned by Subscription.Err when the subscriber was disconnected by the
+	// Disconnect policy for falling behind.
+	ErrSlowSubscriber = errors.New("subscriber disconnected for falling behind")
+)
+
+// What a Broadcaster does when a subscriber's channel is full.
+type SlowSubscriberPolicy int
+
+const (
+	// Wait until the subscriber has room. This slows every publish down to
+	// the pace of the slowest blocking subscriber.
+	Block SlowSubscriberPolicy = iota
+	// Discard the value being published.
+	DropNewest
+	// Discard the oldest buffered value to make room for the new one. For
+	// unbuffered subscriptions this behaves like DropNewest.
+	DropOldest
+	// Unsubscribe the subscriber and close its channel.
+	Disconnect
+)
+
+func (s SlowSubscriberPolicy) String() string {
+	switch s {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop newest"
+	case DropOldest:
+		return "drop oldest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("SlowSubscriberPolicy(%d)", int(s))
+	}
+}
+
+// Sends every published value to every subscriber, unlike a plain channel
+// where each value goes to whichever receiver gets it first. Each subscriber
+// sees values in the order they were published.
+type Broadcaster[T any] struct {
+	// Serializes calls to Publish, which is what guarantees ordering.
+	publishMux sync.Mutex
+
+	mux    sync.Mutex
+	subs   map[int]*Subscription[T]
+	nextID int
+	closed bool
+}
+
+// A single subscriber's view of a Broadcaster.
+type Subscription[T any] struct {
+	b      *Broadcaster[T]
+	id     int
+	policy SlowSubscriberPolicy
+	ch     chan T
+
+	// Closed as soon as we start unsubscribing so that a blocked Publish can
+	// give up on us.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// Guards sending on, and closing, ch.
+	mux    sync.Mutex
+	closed bool
+	err    error
+
+	dropped atomic.Int64
+}
+
+// Creates a new Broadcaster with no subscribers.
+func NewBroadcaster[T any]() *Broadcaster[T] {
+	return &Broadcaster[T]{
+		subs: map[int]*Subscription[T]{},
+	}
+}
+
+// Registers a new subscriber whose channel can buffer the given number of
+// values before the policy kicks in. If the Broadcaster is closed, the
+// subscription's channel is already closed.
+func (b *Broadcaster[T]) Subscribe(buffer int, policy SlowSubscriberPolicy) *Subscription[T] {
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	s := &Subscription[T]{
+		b:      b,
+		id:     b.nextID,
+		policy: policy,
+		ch:     make(chan T, buffer),
+		done:   make(chan struct{}),
+	}
+	b.nextID++
+
+	if b.closed {
+		s.shutdown()
+		return s
+	}
+
+	b.subs[s.id] = s
+
+	return s
+}
+
+// Sends the value to every current subscriber, applying each one's policy if
+// it is behind. Only subscribers with the Block policy can make this wait; if
+// the context ends while waiting, its error is returned and the remaining
+// subscribers do not get the value.
+func (b *Broadcaster[T]) Publish(ctx context.Context, v T) error {
+	b.publishMux.Lock()
+	defer b.publishMux.Unlock()
+
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return ErrBroadcasterClosed
+	}
+
+	// Deliver in subscription order so that the output is predictable.
+	ids := make([]int, 0, len(b.subs))
+	for id := range b.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	subs := make([]*Subscription[T], len(ids))
+	for i, id := range ids {
+		subs[i] = b.subs[id]
+	}
+	b.mux.Unlock()
+
+	for _, s := range subs {
+		disconnected, err := s.deliver(ctx, v)
+		if disconnected {
+			// This happens outside of the subscription's lock so that we never
+			// need both locks at once.
+			b.remove(s.id)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the number of current subscribers.
+func (b *Broadcaster[T]) Len() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.subs)
+}
+
+// Unsubscribes everyone and rejects further publishes.
+func (b *Broadcaster[T]) Close() {
+	b.mux.Lock()
+	b.closed = true
+	subs := b.subs
+	b.subs = map[int]*Subscription[T]{}
+	b.mux.Unlock()
+
+	for _, s := range subs {
+		s.shutdown()
+	}
+}
+
+// Returns the channel that published values arrive on. It is closed once the
+// subscription ends.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// Stops receiving values and closes the channel. It is safe to call more than
+// once, and it will not wait on a Publish that is blocked on this subscriber.
+func (s *Subscription[T]) Unsubscribe() {
+	s.b.remove(s.id)
+	s.shutdown()
+}
+
+// Returns how many values were discarded by the DropNewest or DropOldest
+// policies.
+func (s *Subscription[T]) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Returns ErrSlowSubscriber if the Disconnect policy ended this subscription,
+// otherwise nil.
+func (s *Subscription[T]) Err() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.err
+}
+
+// Reports whether the subscriber was disconnected for being too slow, in which
+// case the caller must remove it from the Broadcaster.
+func (s *Subscription[T]) deliver(ctx context.Context, v T) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.closed {
+		return false, nil
+	}
+
+	// Every policy starts by trying to send without blocking.
+	select {
+	case s.ch <- v:
+		return false, nil
+	default:
+	}
+
+	switch s.policy {
+	case DropNewest:
+		s.dropped.Add(1)
+	case DropOldest:
+		if cap(s.ch) == 0 {
+			s.dropped.Add(1)
+			return false, nil
+		}
+
+		// Our subscriber may have made room since we tried, in which case
+		// there's nothing to drop. Either way there's room now, and since
+		// nobody else sends while we hold s.mux, the send can't block.
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+
+		s.ch <- v
+	case Disconnect:
+		s.closeLocked(ErrSlowSubscriber)
+		return true, nil
+	default:
+		select {
+		case s.ch <- v:
+		case <-s.done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	return false, nil
+}
+
+func (s *Subscription[T]) shutdown() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closeLocked(nil)
+}
+
+// Must be called with s.mux held.
+func (s *Subscription[T]) closeLocked(err error) {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.ch)
+}
+
+func (b *Broadcaster[T]) remove(id int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	delete(b.subs, id)
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Reads everything from the subscription until its channel is closed.
+func drain[T any](s *Subscription[T]) []T {
+	values := []T{}
+	for v := range s.C() {
+		values = append(values, v)
+	}
+
+	return values
+}
+
+func publishAll(t *testing.T, b *Broadcaster[int], values ...int) {
+	t.Helper()
+
+	for _, v := range values {
+		if err := b.Publish(context.Background(), v); err != nil {
+			t.Fatalf("unexpected error publishing %d: %s", v, err)
+		}
+	}
+}
+
+func TestBroadcasterDeliversInOrder(t *testing.T) {
+	b := NewBroadcaster[int]()
+
+	subs := []*Subscription[int]{}
+	for i := 0; i < 3; i++ {
+		subs = append(subs, b.Subscribe(i, Block))
+	}
+
+	wg := sync.WaitGroup{}
+	received := make([][]int, len(subs))
+
+	for i, s := range subs {
+		i, s := i, s
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			received[i] = drain(s)
+		}()
+	}
+
+	expected := []int{}
+	for i := 0; i < 100; i++ {
+		expected = append(expected, i)
+	}
+
+	publishAll(t, b, expected...)
+	b.Close()
+	wg.Wait()
+
+	for i, values := range received {
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("subscriber %d: expected every value in order, got %v", i, values)
+		}
+	}
+}
+
+func TestBroadcasterSlowSubscriberPolicies(t *testing.T) {
+	testCases := []struct {
+		policy          SlowSubscriberPolicy
+		buffer          int
+		expectedValues  []int
+		expectedDropped int64
+		expectedErr     error
+	}{
+		{
+			policy:          DropNewest,
+			buffer:          2,
+			expectedValues:  []int{0, 1},
+			expectedDropped: 3,
+		},
+		{
+			policy:          DropOldest,
+			buffer:          2,
+			expectedValues:  []int{3, 4},
+			expectedDropped: 3,
+		},
+		{
+			policy:          DropOldest,
+			buffer:          0,
+			expectedValues:  []int{},
+			expectedDropped: 5,
+		},
+		{
+			policy:         Disconnect,
+			buffer:         2,
+			expectedValues: []int{0, 1},
+			expectedErr:    ErrSlowSubscriber,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.policy.String(), func(t *testing.T) {
+			b := NewBroadcaster[int]()
+			slow := b.Subscribe(testCase.buffer, testCase.policy)
+			// A subscriber with room for everything is unaffected.
+			other := b.Subscribe(5, Block)
+
+			publishAll(t, b, 0, 1, 2, 3, 4)
+
+			if testCase.policy == Disconnect && b.Len() != 1 {
+				t.Errorf("expected the slow subscriber to be removed, got %d subscribers", b.Len())
+			}
+
+			b.Close()
+
+			if values := drain(slow); !reflect.DeepEqual(values, testCase.expectedValues) {
+				t.Errorf("expected %v, got %v", testCase.expectedValues, values)
+			}
+
+			if slow.Dropped() != testCase.expectedDropped {
+				t.Errorf("expected %d dropped, got %d", testCase.expectedDropped, slow.Dropped())
+			}
+
+			if !errors.Is(slow.Err(), testCase.expectedErr) {
+				t.Errorf("expected Err to be %v, got %v", testCase.expectedErr, slow.Err())
+			}
+
+			if values := drain(other); !reflect.DeepEqual(values, []int{0, 1, 2, 3, 4}) {
+				t.Errorf("expected the other subscriber to get everything, got %v", values)
+			}
+		})
+	}
+}
+
+func TestBroadcasterDropOldestWithConcurrentReader(t *testing.T) {
+	b := NewBroadcaster[int]()
+	s := b.Subscribe(4, DropOldest)
+
+	receivedChan := make(chan []int)
+	go func() {
+		receivedChan <- drain(s)
+	}()
+
+	const total = 10000
+	for i := 0; i < total; i++ {
+		b.Publish(context.Background(), i)
+	}
+
+	b.Close()
+	received := <-receivedChan
+
+	// Every value is either received or dropped, and what is received is
+	// still in order.
+	if int64(len(received))+s.Dropped() != total {
+		t.Errorf("expected %d received plus dropped, got %d + %d", total, len(received), s.Dropped())
+	}
+
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("expected values in order, got %d after %d", received[i], received[i-1])
+		}
+	}
+}
+
+func TestBroadcasterBlock(t *testing.T) {
+	b := NewBroadcaster[int]()
+	s := b.Subscribe(0, Block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := b.Publish(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded while nobody is reading, got %v", err)
+	}
+
+	go func() {
+		b.Publish(context.Background(), 2)
+	}()
+
+	select {
+	case v := <-s.C():
+		if v != 2 {
+			t.Errorf("expected 2, got %d", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the value to arrive once we read")
+	}
+}
+
+func TestBroadcasterUnsubscribeDuringBlockedPublish(t *testing.T) {
+	b := NewBroadcaster[int]()
+	stuck := b.Subscribe(0, Block)
+	after := b.Subscribe(1, Block)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- b.Publish(context.Background(), 1)
+	}()
+
+	select {
+	case err := <-errChan:
+		t.Fatalf("expected Publish to block, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	stuck.Unsubscribe()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Unsubscribe to unblock Publish")
+	}
+
+	if values := drain(stuck); len(values) != 0 {
+		t.Errorf("expected nothing for the unsubscribed subscriber, got %v", values)
+	}
+
+	// Subscribers after the one that left still get the value.
+	if v := <-after.C(); v != 1 {
+		t.Errorf("expected 1, got %d", v)
+	}
+
+	if b.Len() != 1 {
+		t.Errorf("expected 1 subscriber, got %d", b.Len())
+	}
+
+	// Unsubscribing again is harmless.
+	stuck.Unsubscribe()
+}
+
+func TestBroadcasterClose(t *testing.T) {
+	b := NewBroadcaster[int]()
+	s := b.Subscribe(1, Block)
+
+	b.Close()
+
+	if err := b.Publish(context.Background(), 1); !errors.Is(err, ErrBroadcasterClosed) {
+		t.Errorf("expected ErrBroadcasterClosed, got %v", err)
+	}
+
+	if _, ok := <-s.C(); ok {
+		t.Error("expected the subscription to be closed")
+	}
+
+	if _, ok := <-b.Subscribe(1, Block).C(); ok {
+		t.Error("expected subscribing after Close to give a closed channel")
+	}
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by NewBudget when the parent context has no deadline to split up.
+var ErrNoDeadline = errors.New("context has no deadline")
+
+// Describes one stage of work that gets a share of a Budget. A stage gets
+// Fraction of the spendable time if it is set; whatever is left after every
+// fraction has been handed out is split between the other stages in
+// proportion to their Weight. A stage with neither gets no time at all.
+type BudgetStage struct {
+	Name     string
+	Weight   float64
+	Fraction float64
+}
+
+// How much time a stage was given and how much it actually used.
+type StageUsage struct {
+	Name      string
+	Allocated time.Duration
+	Used      time.Duration
+	// How far past its allocation the stage ran, or zero if it didn't.
+	Overrun  time.Duration
+	Started  bool
+	Finished bool
+}
+
+// A summary of how a Budget was spent.
+type BudgetReport struct {
+	Total   time.Duration
+	Reserve time.Duration
+	Stages  []StageUsage
+}
+
+// Returns the stages that ran past their allocation, in stage order.
+func (b BudgetReport) Overruns() []StageUsage {
+	overruns := []StageUsage{}
+
+	for _, stage := range b.Stages {
+		if stage.Overrun > 0 {
+			overruns = append(overruns, stage)
+		}
+	}
+
+	return overruns
+}
+
+func (b BudgetReport) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "budget: %s spendable, %s reserved", b.Total, b.Reserve)
+
+	for _, stage := range b.Stages {
+		fmt.Fprintf(sb, "\n  %s: used %s of %s", stage.Name, stage.Used, stage.Allocated)
+
+		switch {
+		case !stage.Started:
+			sb.WriteString(" (not started)")
+		case !stage.Finished:
+			sb.WriteString(" (still running)")
+		case stage.Overrun > 0:
+			fmt.Fprintf(sb, " (OVERRUN by %s)", stage.Overrun)
+		}
+	}
+
+	return sb.String()
+}
+
+// Splits whatever time a context has left among a series of stages, keeping
+// some back for cleanup. Compare this with childContexts in the contexts
+// lesson, which gives each child a fixed timeout regardless of how much time
+// the parent has left.
+type Budget struct {
+	parent   context.Context
+	deadline time.Time
+	total    time.Duration
+	reserve  time.Duration
+
+	mux    sync.Mutex
+	order  []string
+	stages map[string]*StageUsage
+	starts map[string]time.Time
+}
+
+// Creates a Budget from the time ctx has left minus the reserve. Returns
+// ErrNoDeadline if ctx has no deadline.
+func NewBudget(ctx context.Context, reserve time.Duration, stages ...BudgetStage) (*Budget, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, ErrNoDeadline
+	}
+
+	total := time.Until(deadline) - reserve
+	if total <= 0 {
+		return nil, fmt.Errorf("only %s left before the deadline, which does not cover the %s reserve", time.Until(deadline), reserve)
+	}
+
+	allocations, err := allocateBudget(total, stages)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Budget{
+		parent:   ctx,
+		deadline: deadline.Add(-reserve),
+		total:    total,
+		reserve:  reserve,
+		stages:   map[string]*StageUsage{},
+		starts:   map[string]time.Time{},
+	}
+
+	for i, stage := range stages {
+		b.order = append(b.order, stage.Name)
+		b.stages[stage.Name] = &StageUsage{Name: stage.Name, Allocated: allocations[i]}
+	}
+
+	return b, nil
+}
+
+// Returns a context for the named stage whose deadline is the stage's
+// allocation from now, but never later than the parent's deadline minus the
+// reserve. The returned cancel function records how long the stage took and
+// must be called when the stage is done.
+func (b *Budget) StartStage(name string) (context.Context, context.CancelFunc, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	stage, ok := b.stages[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown budget stage %q", name)
+	}
+
+	if stage.Started {
+		return nil, nil, fmt.Errorf("budget stage %q already started", name)
+	}
+
+	start := time.Now()
+	deadline := start.Add(stage.Allocated)
+	if deadline.After(b.deadline) {
+		deadline = b.deadline
+	}
+
+	ctx, cancel := context.WithDeadline(b.parent, deadline)
+
+	stage.Started = true
+	b.starts[name] = start
+
+	once := sync.Once{}
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			b.finish(name, time.Since(start))
+		})
+	}, nil
+}
+
+// Returns how each stage has spent its allocation so far.
+func (b *Budget) Report() BudgetReport {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	report := BudgetReport{Total: b.total, Reserve: b.reserve}
+
+	for _, name := range b.order {
+		usage := *b.stages[name]
+
+		if usage.Started && !usage.Finished {
+			usage.Used = time.Since(b.starts[name])
+			usage.Overrun = budgetOverrun(usage.Used, usage.Allocated)
+		}
+
+		report.Stages = append(report.Stages, usage)
+	}
+
+	return report
+}
+
+func (b *Budget) finish(name string, used time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	stage := b.stages[name]
+	stage.Finished = true
+	stage.Used = used
+	stage.Overrun = budgetOverrun(used, stage.Allocated)
+}
+
+func budgetOverrun(used, allocated time.Duration) time.Duration {
+	if used > allocated {
+		return used - allocated
+	}
+
+	return 0
+}
+
+func allocateBudget(total time.Duration, stages []BudgetStage) ([]time.Duration, error) {
+	allocations := make([]time.Duration, len(stages))
+	seen := map[string]struct{}{}
+	fractions := 0.0
+	weights := 0.0
+
+	for _, stage := range stages {
+		if _, ok := seen[stage.Name]; ok {
+			return nil, fmt.Errorf("duplicate budget stage %q", stage.Name)
+		}
+		seen[stage.Name] = struct{}{}
+
+		if stage.Fraction < 0 || stage.Weight < 0 {
+			return nil, fmt.Errorf("budget stage %q has a negative fraction or weight", stage.Name)
+		}
+
+		if stage.Fraction > 0 {
+			fractions += stage.Fraction
+		} else {
+			weights += stage.Weight
+		}
+	}
+
+	if fractions > 1 {
+		return nil, fmt.Errorf("budget stage fractions add up to %v, which is more than 1", fractions)
+	}
+
+	remaining := total - time.Duration(float64(total)*fractions)
+
+	for i, stage := range stages {
+		switch {
+		case stage.Fraction > 0:
+			allocations[i] = time.Duration(float64(total) * stage.Fraction)
+		case weights > 0:
+			allocations[i] = time.Duration(float64(remaining) * stage.Weight / weights)
+		}
+	}
+
+	return allocations, nil
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cancels a context created by WithCancelCause, recording why. A nil cause
+// is recorded as context.Canceled. Only the first call has any effect.
+type CancelCauseFunc func(cause error)
+
+// Describes why a context created by this package was cancelled. It matches
+// both the root cause and the context's own error with errors.Is, and the
+// root cause with errors.As.
+type CancellationError struct {
+	// The names of the contexts the cancellation passed through, starting
+	// with the one that was cancelled and ending with the one asked about.
+	// A plain context in the chain shows up as "(context)".
+	Path []string
+	// What the context's Err method returns.
+	Err   error
+	cause error
+}
+
+func (c *CancellationError) Error() string {
+	return fmt.Sprintf("%s: %s", strings.Join(c.Path, " -> "), c.cause)
+}
+
+// Returns the cause given to the cancel function (or the deadline cause) of
+// the context that started the cancellation.
+func (c *CancellationError) Unwrap() error {
+	return c.cause
+}
+
+func (c *CancellationError) Is(target error) bool {
+	return target == c.Err
+}
+
+// Returns the name of the context that started the cancellation.
+func (c *CancellationError) Origin() string {
+	return c.Path[0]
+}
+
+// Reports whether the context asked about was cancelled by one of its
+// ancestors rather than by its own cancel function or deadline.
+func (c *CancellationError) Inherited() bool {
+	return len(c.Path) > 1
+}
+
+type causeContextKey struct{}
+
+// Go 1.20 added context.WithCancelCause, but this module targets Go 1.19,
+// where ctx.Err() can only ever say "context canceled" or "context deadline
+// exceeded". This wraps a regular context and remembers why it ended.
+type causeContext struct {
+	context.Context
+	name          string
+	parent        context.Context
+	cancel        context.CancelFunc
+	deadlineCause error
+
+	mux       sync.Mutex
+	settled   bool
+	cause     error
+	inherited bool
+}
+
+func (c *causeContext) Value(key interface{}) interface{} {
+	if key == (causeContextKey{}) {
+		return c
+	}
+
+	return c.Context.Value(key)
+}
+
+// Like context.WithCancel, but the cancel function takes the reason for the
+// cancellation, which Cause reports. The name identifies this context in
+// the CancellationError's path.
+func WithCancelCause(parent context.Context, name string) (context.Context, CancelCauseFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c := newCauseContext(ctx, cancel, parent, name, nil)
+	return c, c.cancelWithCause
+}
+
+// Like context.WithTimeout, but if the timeout is reached, Cause reports the
+// given cause (or context.DeadlineExceeded if it is nil).
+func WithTimeoutCause(parent context.Context, name string, timeout time.Duration, cause error) (context.Context, CancelCauseFunc) {
+	return WithDeadlineCause(parent, name, time.Now().Add(timeout), cause)
+}
+
+// Like context.WithDeadline, but if the deadline is reached, Cause reports
+// the given cause (or context.DeadlineExceeded if it is nil).
+func WithDeadlineCause(parent context.Context, name string, deadline time.Time, cause error) (context.Context, CancelCauseFunc) {
+	if cause == nil {
+		cause = context.DeadlineExceeded
+	}
+
+	ctx, cancel := context.WithDeadline(parent, deadline)
+	c := newCauseContext(ctx, cancel, parent, name, cause)
+	return c, c.cancelWithCause
+}
+
+// Returns why the context ended: nil if it hasn't, a *CancellationError if
+// it (or an ancestor) was created by this package, or ctx.Err() otherwise.
+func Cause(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	// If the nearest context of ours is still alive, ctx must be a plain
+	// context below it that was cancelled on its own.
+	c, ok := ctx.Value(causeContextKey{}).(*causeContext)
+	if !ok || c.Err() == nil {
+		return ctx.Err()
+	}
+
+	path := []string{}
+
+	for {
+		c.settle()
+
+		c.mux.Lock()
+		inherited, cause := c.inherited, c.cause
+		c.mux.Unlock()
+
+		path = append([]string{c.name}, path...)
+
+		if !inherited {
+			return &CancellationError{Path: path, Err: ctx.Err(), cause: cause}
+		}
+
+		parent, ok := c.parent.Value(causeContextKey{}).(*causeContext)
+		if !ok || parent.Err() == nil {
+			// The cancellation came from a plain context somewhere above us, so
+			// its error is the best cause we have.
+			path = append([]string{"(context)"}, path...)
+			return &CancellationError{Path: path, Err: ctx.Err(), cause: c.parent.Err()}
+		}
+
+		c = parent
+	}
+}
+
+func newCauseContext(ctx context.Context, cancel context.CancelFunc, parent context.Context, name string, deadlineCause error) *causeContext {
+	c := &causeContext{
+		Context:       ctx,
+		name:          name,
+		parent:        parent,
+		cancel:        cancel,
+		deadlineCause: deadlineCause,
+	}
+
+	// Work out why we ended as soon as we do. Waiting until someone asks
+	// could misattribute our own deadline to a parent cancelled afterwards.
+	go func() {
+		<-ctx.Done()
+		c.settle()
+	}()
+
+	return c
+}
+
+func (c *causeContext) cancelWithCause(cause error) {
+	if cause == nil {
+		cause = context.Canceled
+	}
+
+	c.mux.Lock()
+	if !c.settled && c.Context.Err() == nil {
+		c.settled = true
+		c.cause = cause
+	}
+	c.mux.Unlock()
+
+	c.cancel()
+}
+
+// Records why the context ended, if it has and we haven't already.
+func (c *causeContext) settle() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.settled || c.Context.Err() == nil {
+		return
+	}
+
+	c.settled = true
+
+	if c.parent.Err() != nil {
+		c.inherited = true
+		return
+	}
+
+	// The parent is fine and nobody called our cancel function, so it must
+	// have been our own deadline.
+	c.cause = c.deadlineCause
+	if c.cause == nil {
+		c.cause = c.Context.Err()
+	}
+}
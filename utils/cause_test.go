@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errShuttingDown = errors.New("shutting down")
+
+func expectCancellation(t *testing.T, ctx context.Context, path []string, err, cause error) *CancellationError {
+	t.Helper()
+
+	got := Cause(ctx)
+
+	cancellation := &CancellationError{}
+	if !errors.As(got, &cancellation) {
+		t.Fatalf("expected a *CancellationError, got %v", got)
+	}
+
+	if !reflect.DeepEqual(cancellation.Path, path) {
+		t.Errorf("expected path %v, got %v", path, cancellation.Path)
+	}
+
+	if !errors.Is(got, err) || cancellation.Err != err {
+		t.Errorf("expected it to match %s, got %v", err, cancellation.Err)
+	}
+
+	if !errors.Is(got, cause) {
+		t.Errorf("expected it to wrap %s, got %s", cause, got)
+	}
+
+	if cancellation.Origin() != path[0] || cancellation.Inherited() != (len(path) > 1) {
+		t.Errorf("unexpected origin %q or inherited %v for path %v", cancellation.Origin(), cancellation.Inherited(), path)
+	}
+
+	return cancellation
+}
+
+// Waits until the context has worked out why it ended.
+func waitForSettled(t *testing.T, ctx context.Context) {
+	t.Helper()
+
+	c := ctx.(*causeContext)
+	waitUntil(t, func() bool {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return c.settled
+	})
+}
+
+func TestCauseNotEnded(t *testing.T) {
+	ctx, cancel := WithCancelCause(context.Background(), "ctx")
+	defer cancel(nil)
+
+	if err := Cause(ctx); err != nil {
+		t.Errorf("expected nil, got %s", err)
+	}
+}
+
+func TestCauseOwnCancellation(t *testing.T) {
+	ctx, cancel := WithCancelCause(context.Background(), "server")
+	cancel(errShuttingDown)
+	// Only the first cause counts.
+	cancel(errDependency)
+
+	cancellation := expectCancellation(t, ctx, []string{"server"}, context.Canceled, errShuttingDown)
+
+	if cancellation.Error() != "server: shutting down" {
+		t.Errorf("unexpected message: %s", cancellation.Error())
+	}
+
+	ctx, cancel = WithCancelCause(context.Background(), "server")
+	cancel(nil)
+	expectCancellation(t, ctx, []string{"server"}, context.Canceled, context.Canceled)
+}
+
+func TestCauseOwnTimeout(t *testing.T) {
+	errTooSlow := errors.New("too slow")
+
+	ctx, cancel := WithTimeoutCause(context.Background(), "request", time.Millisecond, errTooSlow)
+	defer cancel(nil)
+	<-ctx.Done()
+
+	expectCancellation(t, ctx, []string{"request"}, context.DeadlineExceeded, errTooSlow)
+
+	ctx, cancel = WithTimeoutCause(context.Background(), "request", time.Millisecond, nil)
+	defer cancel(nil)
+	<-ctx.Done()
+
+	expectCancellation(t, ctx, []string{"request"}, context.DeadlineExceeded, context.DeadlineExceeded)
+}
+
+func TestCauseInherited(t *testing.T) {
+	root, cancelRoot := WithCancelCause(context.Background(), "root")
+	middle, cancelMiddle := WithTimeoutCause(root, "middle", time.Minute, nil)
+	defer cancelMiddle(nil)
+	leaf, cancelLeaf := WithCancelCause(middle, "leaf")
+	defer cancelLeaf(nil)
+
+	cancelRoot(errShuttingDown)
+	<-leaf.Done()
+
+	expectCancellation(t, leaf, []string{"root", "middle", "leaf"}, context.Canceled, errShuttingDown)
+	expectCancellation(t, middle, []string{"root", "middle"}, context.Canceled, errShuttingDown)
+}
+
+func TestCauseOwnTimeoutBeforeParentCancelled(t *testing.T) {
+	errTooSlow := errors.New("too slow")
+
+	parent, cancelParent := WithCancelCause(context.Background(), "parent")
+	child, cancelChild := WithTimeoutCause(parent, "child", time.Millisecond, errTooSlow)
+	defer cancelChild(nil)
+
+	<-child.Done()
+	waitForSettled(t, child)
+
+	// The parent ending afterwards must not take the blame, even though
+	// nobody had asked about the child yet.
+	cancelParent(errShuttingDown)
+
+	cancellation := expectCancellation(t, child, []string{"child"}, context.DeadlineExceeded, errTooSlow)
+	if errors.Is(cancellation, errShuttingDown) {
+		t.Errorf("expected the parent's cause not to be reported, got %s", cancellation)
+	}
+}
+
+func TestCausePlainContextInChain(t *testing.T) {
+	t.Run("PlainContextCancelled", func(t *testing.T) {
+		root, cancelRoot := WithCancelCause(context.Background(), "root")
+		defer cancelRoot(nil)
+		plain, cancelPlain := context.WithCancel(root)
+		leaf, cancelLeaf := WithCancelCause(plain, "leaf")
+		defer cancelLeaf(nil)
+
+		cancelPlain()
+		<-leaf.Done()
+
+		expectCancellation(t, leaf, []string{"(context)", "leaf"}, context.Canceled, context.Canceled)
+	})
+
+	t.Run("AncestorCancelled", func(t *testing.T) {
+		root, cancelRoot := WithCancelCause(context.Background(), "root")
+		plain, cancelPlain := context.WithCancel(root)
+		defer cancelPlain()
+		leaf, cancelLeaf := WithCancelCause(plain, "leaf")
+		defer cancelLeaf(nil)
+
+		cancelRoot(errShuttingDown)
+		<-leaf.Done()
+
+		// The plain context only passed the cancellation along.
+		expectCancellation(t, leaf, []string{"root", "leaf"}, context.Canceled, errShuttingDown)
+	})
+
+	t.Run("PlainContextBelow", func(t *testing.T) {
+		root, cancelRoot := WithCancelCause(context.Background(), "root")
+		defer cancelRoot(nil)
+		plain, cancelPlain := context.WithCancel(root)
+
+		cancelPlain()
+
+		if err := Cause(plain); err != context.Canceled {
+			t.Errorf("expected context.Canceled for a plain context cancelled on its own, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Matches (via errors.Is) every *CircuitOpenError.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Tells the time. Things that depend on the time accept one of these so that
+// tests can control it instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The Clock backed by time.Now.
+var SystemClock Clock = systemClock{}
+
+// The state a CircuitBreaker is in.
+type CircuitState int
+
+const (
+	// Calls go through and their outcomes are counted.
+	CircuitClosed CircuitState = iota
+	// Calls are rejected without being attempted.
+	CircuitOpen
+	// A limited number of trial calls go through to see whether the
+	// dependency has recovered.
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(c))
+	}
+}
+
+// How a CircuitBreaker counts the outcome of a call.
+type CircuitOutcome int
+
+const (
+	CircuitSuccess CircuitOutcome = iota
+	CircuitFailure
+	// The call says nothing about the dependency's health, so it isn't
+	// counted at all. A half-open trial that ends this way frees its slot for
+	// another trial.
+	CircuitIgnore
+)
+
+// Returned instead of calling the function when the breaker is open, or when
+// it is half-open and already has as many trial calls as it allows.
+type CircuitOpenError struct {
+	Name  string
+	State CircuitState
+	// How long until the breaker will next let a trial call through. Zero when
+	// half-open.
+	RetryAfter time.Duration
+}
+
+func (c *CircuitOpenError) Error() string {
+	if c.State == CircuitHalfOpen {
+		return fmt.Sprintf("circuit breaker %q is half-open and at its trial limit", c.Name)
+	}
+
+	return fmt.Sprintf("circuit breaker %q is open, retry after %s", c.Name, c.RetryAfter)
+}
+
+func (c *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Configures a CircuitBreaker. If neither ConsecutiveFailures nor
+// FailureRatio is set, the breaker trips after 5 consecutive failures.
+type CircuitBreakerOptions struct {
+	Name string
+	// Trip after this many failures in a row.
+	ConsecutiveFailures int
+	// Trip when at least this fraction of the calls within Window failed, as
+	// long as there were at least MinRequests of them. Window defaults to 10s
+	// and MinRequests to 10.
+	FailureRatio float64
+	Window       time.Duration
+	MinRequests  int
+	// How long to stay open before letting trial calls through. Defaults to 5s.
+	OpenTimeout time.Duration
+	// How many trial calls may run at once while half-open. That many must
+	// succeed in a row for the breaker to close again. Defaults to 1.
+	HalfOpenMaxRequests int
+	// Decides how a call's error is counted. Defaults to ignoring
+	// context.Canceled, since a caller giving up says nothing about the
+	// dependency's health, and counting any other non-nil error as a failure.
+	Classify func(error) CircuitOutcome
+	// Called after every state change, outside of the breaker's lock.
+	OnStateChange func(name string, from, to CircuitState)
+	// Defaults to SystemClock.
+	Clock Clock
+}
+
+type circuitOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// Stops calling a dependency that keeps failing, giving it time to recover,
+// and then carefully starts calling it again.
+type CircuitBreaker struct {
+	opts CircuitBreakerOptions
+
+	mux   sync.Mutex
+	state CircuitState
+	// Increases on every state change so that we can ignore outcomes of calls
+	// that started in an earlier state.
+	generation  uint64
+	openedAt    time.Time
+	consecutive int
+	outcomes    []circuitOutcome
+	trials      int
+	successes   int
+}
+
+// Creates a new CircuitBreaker in the closed state.
+func NewCircuitBreaker(opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.ConsecutiveFailures == 0 && opts.FailureRatio == 0 {
+		opts.ConsecutiveFailures = 5
+	}
+
+	if opts.Window == 0 {
+		opts.Window = 10 * time.Second
+	}
+
+	if opts.MinRequests == 0 {
+		opts.MinRequests = 10
+	}
+
+	if opts.OpenTimeout == 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+
+	if opts.HalfOpenMaxRequests == 0 {
+		opts.HalfOpenMaxRequests = 1
+	}
+
+	if opts.Classify == nil {
+		opts.Classify = func(err error) CircuitOutcome {
+			switch {
+			case err == nil:
+				return CircuitSuccess
+			case errors.Is(err, context.Canceled):
+				return CircuitIgnore
+			default:
+				return CircuitFailure
+			}
+		}
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+
+	return &CircuitBreaker{opts: opts}
+}
+
+// Returns the current state.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mux.Lock()
+	state, changes := c.currentState(c.opts.Clock.Now())
+	c.mux.Unlock()
+
+	c.notify(changes)
+
+	return state
+}
+
+// Calls fn if the breaker allows it and records the outcome. Returns a
+// *CircuitOpenError without calling fn if it doesn't. A panic in fn becomes a
+// *PanicError, which counts as a failure by default.
+func (c *CircuitBreaker) Execute(fn func() error) error {
+	done, err := c.Allow()
+	if err != nil {
+		return err
+	}
+
+	// A panic that got past us would leave a half-open trial slot taken
+	// forever.
+	err = callRecovered(fn)
+	done(err)
+
+	return err
+}
+
+// The two-step version of Execute for when the call can't be wrapped in a
+// function. If the call is allowed, the returned function must be called
+// exactly once with the call's error.
+func (c *CircuitBreaker) Allow() (func(error), error) {
+	c.mux.Lock()
+	now := c.opts.Clock.Now()
+	state, changes := c.currentState(now)
+
+	var err error
+	switch state {
+	case CircuitOpen:
+		err = &CircuitOpenError{Name: c.opts.Name, State: state, RetryAfter: c.openedAt.Add(c.opts.OpenTimeout).Sub(now)}
+	case CircuitHalfOpen:
+		if c.trials >= c.opts.HalfOpenMaxRequests {
+			err = &CircuitOpenError{Name: c.opts.Name, State: state}
+		} else {
+			c.trials++
+		}
+	}
+
+	generation := c.generation
+	c.mux.Unlock()
+
+	c.notify(changes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	once := sync.Once{}
+	return func(callErr error) {
+		once.Do(func() {
+			c.record(generation, c.opts.Classify(callErr))
+		})
+	}, nil
+}
+
+func (c *CircuitBreaker) record(generation uint64, outcome CircuitOutcome) {
+	c.mux.Lock()
+	now := c.opts.Clock.Now()
+	_, changes := c.currentState(now)
+
+	if generation != c.generation {
+		c.mux.Unlock()
+		c.notify(changes)
+		return
+	}
+
+	failed := outcome == CircuitFailure
+
+	switch c.state {
+	case CircuitClosed:
+		if outcome == CircuitIgnore {
+			break
+		}
+
+		c.outcomes = append(c.pruneOutcomes(now), circuitOutcome{at: now, failed: failed})
+
+		if failed {
+			c.consecutive++
+		} else {
+			c.consecutive = 0
+		}
+
+		if c.shouldTrip() {
+			changes = append(changes, c.setState(CircuitOpen, now))
+		}
+	case CircuitHalfOpen:
+		c.trials--
+
+		if outcome == CircuitIgnore {
+			break
+		}
+
+		if failed {
+			changes = append(changes, c.setState(CircuitOpen, now))
+		} else {
+			c.successes++
+			if c.successes >= c.opts.HalfOpenMaxRequests {
+				changes = append(changes, c.setState(CircuitClosed, now))
+			}
+		}
+	}
+
+	c.mux.Unlock()
+	c.notify(changes)
+}
+
+func (c *CircuitBreaker) shouldTrip() bool {
+	if c.opts.ConsecutiveFailures > 0 && c.consecutive >= c.opts.ConsecutiveFailures {
+		return true
+	}
+
+	if c.opts.FailureRatio <= 0 || len(c.outcomes) < c.opts.MinRequests {
+		return false
+	}
+
+	failures := 0
+	for _, outcome := range c.outcomes {
+		if outcome.failed {
+			failures++
+		}
+	}
+
+	return float64(failures)/float64(len(c.outcomes)) >= c.opts.FailureRatio
+}
+
+// Drops outcomes that have fallen out of the sliding window.
+func (c *CircuitBreaker) pruneOutcomes(now time.Time) []circuitOutcome {
+	cutoff := now.Add(-c.opts.Window)
+
+	i := 0
+	for i < len(c.outcomes) && !c.outcomes[i].at.After(cutoff) {
+		i++
+	}
+
+	return c.outcomes[i:]
+}
+
+// Moves from open to half-open once the timeout has passed. Must be called
+// with c.mux held.
+func (c *CircuitBreaker) currentState(now time.Time) (CircuitState, []circuitChange) {
+	if c.state == CircuitOpen && !now.Before(c.openedAt.Add(c.opts.OpenTimeout)) {
+		return CircuitHalfOpen, []circuitChange{c.setState(CircuitHalfOpen, now)}
+	}
+
+	return c.state, nil
+}
+
+type circuitChange struct {
+	from, to CircuitState
+}
+
+// Must be called with c.mux held.
+func (c *CircuitBreaker) setState(to CircuitState, now time.Time) circuitChange {
+	change := circuitChange{from: c.state, to: to}
+
+	c.state = to
+	c.generation++
+	c.consecutive = 0
+	c.outcomes = nil
+	c.trials = 0
+	c.successes = 0
+
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+
+	return change
+}
+
+func (c *CircuitBreaker) notify(changes []circuitChange) {
+	if c.opts.OnStateChange == nil {
+		return
+	}
+
+	for _, change := range changes {
+		c.opts.OnStateChange(c.opts.Name, change.from, change.to)
+	}
+}
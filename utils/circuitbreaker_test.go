@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.now = f.now.Add(d)
+}
+
+var errDependency = errors.New("dependency failed")
+
+func failingCall() error {
+	return errDependency
+}
+
+func succeedingCall() error {
+	return nil
+}
+
+func cancelledCall() error {
+	return context.Canceled
+}
+
+func expectState(t *testing.T, cb *CircuitBreaker, want CircuitState) {
+	t.Helper()
+
+	if got := cb.State(); got != want {
+		t.Fatalf("expected breaker to be %s, got %s", want, got)
+	}
+}
+
+func TestCircuitBreakerTripsOnConsecutiveFailures(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(CircuitBreakerOptions{Name: "test", ConsecutiveFailures: 3, OpenTimeout: time.Second, Clock: clock})
+
+	cb.Execute(failingCall)
+	cb.Execute(failingCall)
+	cb.Execute(succeedingCall)
+	cb.Execute(failingCall)
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitClosed)
+
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitOpen)
+
+	called := false
+	err := cb.Execute(func() error {
+		called = true
+		return nil
+	})
+
+	if called {
+		t.Error("expected the function not to be called while open")
+	}
+
+	openErr := &CircuitOpenError{}
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &openErr) {
+		t.Fatalf("expected a *CircuitOpenError, got %v", err)
+	}
+
+	if openErr.RetryAfter != time.Second {
+		t.Errorf("expected RetryAfter to be 1s, got %s", openErr.RetryAfter)
+	}
+}
+
+func TestCircuitBreakerTripsOnFailureRatio(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(CircuitBreakerOptions{FailureRatio: 0.5, Window: 10 * time.Second, MinRequests: 4, Clock: clock})
+
+	cb.Execute(failingCall)
+	cb.Execute(succeedingCall)
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitClosed)
+
+	// Outcomes that fall out of the window no longer count.
+	clock.Advance(11 * time.Second)
+	cb.Execute(succeedingCall)
+	cb.Execute(succeedingCall)
+	cb.Execute(failingCall)
+	cb.Execute(succeedingCall)
+	expectState(t, cb, CircuitClosed)
+
+	cb.Execute(failingCall)
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitOpen)
+}
+
+func TestCircuitBreakerIgnoresCancelledCallsWhenClosed(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerOptions{ConsecutiveFailures: 3, Clock: newFakeClock()})
+
+	cb.Execute(failingCall)
+	cb.Execute(failingCall)
+	// A cancelled call must not reset the run of failures.
+	cb.Execute(cancelledCall)
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitOpen)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	opts := CircuitBreakerOptions{ConsecutiveFailures: 1, OpenTimeout: time.Second, HalfOpenMaxRequests: 2, Clock: clock}
+
+	changes := []string{}
+	opts.OnStateChange = func(name string, from, to CircuitState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	cb := NewCircuitBreaker(opts)
+
+	cb.Execute(failingCall)
+	clock.Advance(time.Second)
+	expectState(t, cb, CircuitHalfOpen)
+
+	done1, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("expected first trial to be allowed, got %s", err)
+	}
+
+	done2, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("expected second trial to be allowed, got %s", err)
+	}
+
+	if _, err := cb.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected third trial to be rejected, got %v", err)
+	}
+
+	// A cancelled trial gives its slot back without counting as a success.
+	done1(context.Canceled)
+	expectState(t, cb, CircuitHalfOpen)
+
+	done3, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("expected the freed trial slot to be reused, got %s", err)
+	}
+
+	done2(nil)
+	expectState(t, cb, CircuitHalfOpen)
+
+	done3(nil)
+	expectState(t, cb, CircuitClosed)
+
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected state changes %v, got %v", expected, changes)
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("expected state changes %v, got %v", expected, changes)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(CircuitBreakerOptions{ConsecutiveFailures: 1, OpenTimeout: time.Second, Clock: clock})
+
+	cb.Execute(failingCall)
+	clock.Advance(time.Second)
+	cb.Execute(failingCall)
+	expectState(t, cb, CircuitOpen)
+}
+
+func TestCircuitBreakerIgnoresStaleOutcomes(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(CircuitBreakerOptions{ConsecutiveFailures: 1, OpenTimeout: time.Second, Clock: clock})
+
+	done, err := cb.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb.Execute(failingCall)
+	clock.Advance(time.Second)
+	expectState(t, cb, CircuitHalfOpen)
+
+	// This call started while the breaker was closed, so it says nothing
+	// about the trial.
+	done(nil)
+	expectState(t, cb, CircuitHalfOpen)
+}
+
+func TestCircuitBreakerRecoversPanickingTrial(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(CircuitBreakerOptions{ConsecutiveFailures: 1, OpenTimeout: time.Second, Clock: clock})
+
+	cb.Execute(failingCall)
+	clock.Advance(time.Second)
+	expectState(t, cb, CircuitHalfOpen)
+
+	err := cb.Execute(func() error {
+		panic("boom")
+	})
+
+	panicErr := &PanicError{}
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+
+	// The panic counts as a failed trial, and the breaker recovers as usual
+	// once the timeout passes again.
+	expectState(t, cb, CircuitOpen)
+
+	clock.Advance(time.Second)
+	if err := cb.Execute(succeedingCall); err != nil {
+		t.Fatalf("expected the next trial to be allowed, got %s", err)
+	}
+
+	expectState(t, cb, CircuitClosed)
+}
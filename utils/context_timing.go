@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Describes how a context-aware timed function ended.
+type TimingOutcome int
+
+const (
+	// The function ran to completion before its context ended.
+	Finished TimingOutcome = iota
+	// The context's deadline was reached before the function finished.
+	TimedOut
+	// The context was cancelled before the function finished.
+	Cancelled
+)
+
+func (t TimingOutcome) String() string {
+	switch t {
+	case Finished:
+		return "finished"
+	case TimedOut:
+		return "timed out"
+	case Cancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("TimingOutcome(%d)", int(t))
+	}
+}
+
+// What TimeItContext and NamedSleepContext return. When the context ended
+// first, Elapsed is the partial time spent before we gave up.
+type TimingResult struct {
+	Name    string
+	Elapsed time.Duration
+	Outcome TimingOutcome
+}
+
+// Returns the context's error if the function did not finish, otherwise nil.
+func (t TimingResult) Err() error {
+	switch t.Outcome {
+	case TimedOut:
+		return context.DeadlineExceeded
+	case Cancelled:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (t TimingResult) String() string {
+	if t.Outcome == Finished {
+		return fmt.Sprintf("%s finished running in %s", t.Name, t.Elapsed)
+	}
+
+	return fmt.Sprintf("%s %s after %s", t.Name, t.Outcome, t.Elapsed)
+}
+
+// Like TimeIt, but returns as soon as the context ends. The function is given
+// the context so that it can stop as well; if it ignores the context, it will
+// keep running in the background after we return.
+func TimeItContext(ctx context.Context, name string, f func(context.Context)) TimingResult {
+	return DefaultTimingRegistry.TimeItContext(ctx, name, f)
+}
+
+// Sleeps for a given time unless the context ends first and outputs its name
+// along with how it ended.
+func NamedSleepContext(ctx context.Context, name string, d time.Duration) TimingResult {
+	return TimeItContext(ctx, name, func(ctx context.Context) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// See the TimeItContext package function.
+func (t *TimingRegistry) TimeItContext(ctx context.Context, name string, f func(context.Context)) TimingResult {
+	start := time.Now()
+	doneChan := make(chan struct{})
+	// Whether the context had ended by the time f returned. Only read once
+	// doneChan is closed.
+	var ctxErr error
+
+	go func() {
+		defer close(doneChan)
+		f(ctx)
+		ctxErr = ctx.Err()
+	}()
+
+	result := TimingResult{Name: name, Outcome: Finished}
+
+	var err error
+
+	select {
+	case <-doneChan:
+		// A function that honours the context will return right after it
+		// ends, so we may see doneChan close even though it was cut short. We
+		// only count it as finished if the context was still alive when it
+		// returned, not if it ended afterwards.
+		err = ctxErr
+	case <-ctx.Done():
+		// If f has also returned, the select may have picked this case at
+		// random, so we go by what the context looked like when it did.
+		select {
+		case <-doneChan:
+			err = ctxErr
+		default:
+			err = ctx.Err()
+		}
+	}
+
+	if err != nil {
+		result.Outcome = outcomeFromContext(err)
+	}
+
+	result.Elapsed = time.Since(start)
+	t.Record(name, result.Elapsed)
+
+	if result.Outcome == Finished {
+		t.println(fmt.Sprintf("%s finished running in Goroutine %d in %s", name, GetGoroutineID(), result.Elapsed))
+	} else {
+		t.println(fmt.Sprintf("%s %s in Goroutine %d after %s", name, result.Outcome, GetGoroutineID(), result.Elapsed))
+	}
+
+	return result
+}
+
+func outcomeFromContext(err error) TimingOutcome {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return TimedOut
+	}
+
+	return Cancelled
+}
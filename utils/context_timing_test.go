@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeItContext(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sleep    time.Duration
+		timeout  time.Duration
+		cancel   bool
+		expected TimingOutcome
+	}{
+		{
+			name:     "Finished",
+			sleep:    time.Millisecond,
+			timeout:  time.Minute,
+			expected: Finished,
+		},
+		{
+			name:     "TimedOut",
+			sleep:    time.Minute,
+			timeout:  time.Millisecond,
+			expected: TimedOut,
+		},
+		{
+			name:     "Cancelled",
+			sleep:    time.Minute,
+			timeout:  time.Minute,
+			cancel:   true,
+			expected: Cancelled,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testCase.timeout)
+			defer cancel()
+
+			if testCase.cancel {
+				time.AfterFunc(time.Millisecond, cancel)
+			}
+
+			registry := NewTimingRegistry(nil)
+			result := registry.TimeItContext(ctx, testCase.name, func(ctx context.Context) {
+				timer := time.NewTimer(testCase.sleep)
+				defer timer.Stop()
+
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+				}
+			})
+
+			if result.Outcome != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, result.Outcome)
+			}
+
+			if result.Elapsed >= time.Minute {
+				t.Errorf("expected to return early, took %s", result.Elapsed)
+			}
+		})
+	}
+}
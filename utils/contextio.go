@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Returned when a context ends partway through reading, writing or copying.
+// It matches the context's error with errors.Is.
+type InterruptedError struct {
+	// "read", "write" or "copy".
+	Op string
+	// How many bytes were transferred before we stopped. For a copy, this is
+	// how many were written.
+	N   int64
+	Err error
+}
+
+func (i *InterruptedError) Error() string {
+	return fmt.Sprintf("%s interrupted after %d bytes: %s", i.Op, i.N, i.Err)
+}
+
+func (i *InterruptedError) Unwrap() error {
+	return i.Err
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+type ioResult struct {
+	n   int
+	err error
+}
+
+// Wraps an io.Reader so that reads stop when a context ends.
+//
+// If the reader supports read deadlines (pipes, sockets and terminals
+// opened with os, and net.Conns do; regular files don't), the context's
+// deadline becomes the read deadline, and cancelling the context interrupts
+// a blocked read straight away. Otherwise each read happens in its own
+// Goroutine, which is abandoned if the context ends first; whatever it
+// eventually reads is lost.
+//
+// Like most readers, it is not safe for concurrent reads.
+type ContextReader struct {
+	ctx       context.Context
+	r         io.Reader
+	deadlines readDeadliner
+	n         atomic.Int64
+}
+
+// Creates a new ContextReader.
+func NewContextReader(ctx context.Context, r io.Reader) *ContextReader {
+	c := &ContextReader{ctx: ctx, r: r}
+
+	// Setting the zero time clears any deadline and tells us whether they are
+	// supported at all.
+	if d, ok := r.(readDeadliner); ok && d.SetReadDeadline(time.Time{}) == nil {
+		c.deadlines = d
+	}
+
+	return c
+}
+
+// Returns how many bytes have been read so far.
+func (c *ContextReader) N() int64 {
+	return c.n.Load()
+}
+
+// Reads from the underlying reader, returning an *InterruptedError if the
+// context has ended or ends before the read completes.
+func (c *ContextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, c.interrupted(err)
+	}
+
+	if c.deadlines != nil {
+		stop := watchDeadline(c.ctx, c.deadlines.SetReadDeadline)
+		n, err := c.r.Read(p)
+		stop()
+
+		c.n.Add(int64(n))
+		return n, c.translate(err)
+	}
+
+	if c.ctx.Done() == nil {
+		// The context can't be cancelled, so there's no need for a Goroutine.
+		n, err := c.r.Read(p)
+		c.n.Add(int64(n))
+		return n, err
+	}
+
+	// The abandoned Goroutine may still write to its buffer after we return,
+	// so it can't be p.
+	buf := make([]byte, len(p))
+	done := make(chan ioResult, 1)
+
+	go func() {
+		n, err := c.r.Read(buf)
+		done <- ioResult{n: n, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		n := copy(p, buf[:res.n])
+		c.n.Add(int64(n))
+		return n, res.err
+	case <-c.ctx.Done():
+		return 0, c.interrupted(c.ctx.Err())
+	}
+}
+
+func (c *ContextReader) translate(err error) error {
+	if ctxErr := deadlineCause(c.ctx, err); ctxErr != nil {
+		return c.interrupted(ctxErr)
+	}
+
+	return err
+}
+
+func (c *ContextReader) interrupted(err error) error {
+	return &InterruptedError{Op: "read", N: c.n.Load(), Err: err}
+}
+
+// Wraps an io.Writer so that writes stop when a context ends. It uses write
+// deadlines where it can, in the same way that ContextReader uses read
+// deadlines. Otherwise each write happens in its own Goroutine, and if the
+// context ends first, some of the data may still be written afterwards
+// without being counted.
+type ContextWriter struct {
+	ctx       context.Context
+	w         io.Writer
+	deadlines writeDeadliner
+	n         atomic.Int64
+}
+
+// Creates a new ContextWriter.
+func NewContextWriter(ctx context.Context, w io.Writer) *ContextWriter {
+	c := &ContextWriter{ctx: ctx, w: w}
+
+	if d, ok := w.(writeDeadliner); ok && d.SetWriteDeadline(time.Time{}) == nil {
+		c.deadlines = d
+	}
+
+	return c
+}
+
+// Returns how many bytes have been written so far.
+func (c *ContextWriter) N() int64 {
+	return c.n.Load()
+}
+
+// Writes to the underlying writer, returning an *InterruptedError if the
+// context has ended or ends before the write completes.
+func (c *ContextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, c.interrupted(err)
+	}
+
+	if c.deadlines != nil {
+		stop := watchDeadline(c.ctx, c.deadlines.SetWriteDeadline)
+		n, err := c.w.Write(p)
+		stop()
+
+		c.n.Add(int64(n))
+		return n, c.translate(err)
+	}
+
+	if c.ctx.Done() == nil {
+		n, err := c.w.Write(p)
+		c.n.Add(int64(n))
+		return n, err
+	}
+
+	// Writers mustn't hold on to p once Write returns, and the Goroutine may
+	// outlive this call, so it gets a copy.
+	buf := append([]byte{}, p...)
+	done := make(chan ioResult, 1)
+
+	go func() {
+		n, err := c.w.Write(buf)
+		done <- ioResult{n: n, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		c.n.Add(int64(res.n))
+		return res.n, res.err
+	case <-c.ctx.Done():
+		return 0, c.interrupted(c.ctx.Err())
+	}
+}
+
+func (c *ContextWriter) translate(err error) error {
+	if ctxErr := deadlineCause(c.ctx, err); ctxErr != nil {
+		return c.interrupted(ctxErr)
+	}
+
+	return err
+}
+
+func (c *ContextWriter) interrupted(err error) error {
+	return &InterruptedError{Op: "write", N: c.n.Load(), Err: err}
+}
+
+// Like io.Copy, but stops when the context ends, returning an
+// *InterruptedError that records how many bytes were written.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	n, err := io.Copy(NewContextWriter(ctx, dst), NewContextReader(ctx, src))
+
+	interrupted := &InterruptedError{}
+	if errors.As(err, &interrupted) {
+		return n, &InterruptedError{Op: "copy", N: n, Err: interrupted.Err}
+	}
+
+	return n, err
+}
+
+// Returns the context's error if err is a deadline we set on its behalf
+// expiring. The OS can report the deadline a moment before the context's own
+// timer fires, so a deadline that has passed counts even if the context
+// hasn't noticed yet.
+func deadlineCause(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+// Sets the context's deadline (if any) on a file or connection, and moves it
+// into the past if the context is cancelled so that a blocked operation
+// returns straight away. The returned function stops watching and clears the
+// deadline again.
+func watchDeadline(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		select {
+		case <-ctx.Done():
+			setDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-exited
+		setDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func expectInterrupted(t *testing.T, err error, op string, n int64, cause error) {
+	t.Helper()
+
+	interrupted := &InterruptedError{}
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("expected an *InterruptedError, got %v", err)
+	}
+
+	if interrupted.Op != op || interrupted.N != n || !errors.Is(err, cause) {
+		t.Errorf("expected %s interrupted after %d bytes by %s, got %s", op, n, cause, err)
+	}
+}
+
+func newPipe(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+
+	return r, w
+}
+
+func TestContextReaderDeadlines(t *testing.T) {
+	testCases := []struct {
+		name  string
+		ctx   func() (context.Context, context.CancelFunc)
+		cause error
+	}{
+		{
+			name: "TimedOut",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			cause: context.DeadlineExceeded,
+		},
+		{
+			name: "Cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			cause: context.Canceled,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			r, w := newPipe(t)
+
+			ctx, cancel := testCase.ctx()
+			defer cancel()
+
+			cr := NewContextReader(ctx, r)
+			if cr.deadlines == nil {
+				t.Fatal("expected a pipe to support read deadlines")
+			}
+
+			w.Write([]byte("hello"))
+
+			buf := make([]byte, 10)
+			if n, err := cr.Read(buf); n != 5 || err != nil {
+				t.Fatalf("expected to read 5 bytes, got %d, %v", n, err)
+			}
+
+			// Nothing else is coming, so this blocks until the context ends.
+			n, err := cr.Read(buf)
+			if n != 0 {
+				t.Errorf("expected to read nothing, got %d bytes", n)
+			}
+
+			expectInterrupted(t, err, "read", 5, testCase.cause)
+
+			// Once the context has ended, reads fail straight away.
+			_, err = cr.Read(buf)
+			expectInterrupted(t, err, "read", 5, testCase.cause)
+		})
+	}
+}
+
+func TestContextReaderFallback(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cr := NewContextReader(ctx, r)
+	if cr.deadlines != nil {
+		t.Fatal("expected io.Pipe not to support read deadlines")
+	}
+
+	go w.Write([]byte("abc"))
+
+	buf := make([]byte, 10)
+	if n, err := cr.Read(buf); n != 3 || err != nil || string(buf[:n]) != "abc" {
+		t.Fatalf("expected to read abc, got %q, %v", buf[:n], err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err := cr.Read(buf)
+	expectInterrupted(t, err, "read", 3, context.Canceled)
+
+	if cr.N() != 3 {
+		t.Errorf("expected N to be 3, got %d", cr.N())
+	}
+}
+
+func TestContextWriterDeadlines(t *testing.T) {
+	_, w := newPipe(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	cw := NewContextWriter(ctx, w)
+	if cw.deadlines == nil {
+		t.Fatal("expected a pipe to support write deadlines")
+	}
+
+	// Nobody reads, so this fills the pipe's buffer and then blocks.
+	n, err := cw.Write(make([]byte, 4<<20))
+	if n == 0 {
+		t.Error("expected a partial write")
+	}
+
+	expectInterrupted(t, err, "write", int64(n), context.DeadlineExceeded)
+}
+
+func TestContextWriterFallback(t *testing.T) {
+	r, w := io.Pipe()
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	cw := NewContextWriter(ctx, w)
+
+	_, err := cw.Write([]byte("abc"))
+	expectInterrupted(t, err, "write", 0, context.Canceled)
+}
+
+func TestCopyContext(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		w.Write([]byte("abc"))
+		time.AfterFunc(20*time.Millisecond, cancel)
+	}()
+
+	dst := &bytes.Buffer{}
+	n, err := CopyContext(ctx, dst, r)
+
+	if n != 3 || dst.String() != "abc" {
+		t.Errorf("expected to copy abc, got %d bytes: %q", n, dst.String())
+	}
+
+	expectInterrupted(t, err, "copy", 3, context.Canceled)
+}
+
+// Reports its deadline as soon as it passes, before the context's own timer
+// has necessarily fired.
+type eagerDeadlineReader struct {
+	mux      sync.Mutex
+	deadline time.Time
+}
+
+func (e *eagerDeadlineReader) SetReadDeadline(t time.Time) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.deadline = t
+	return nil
+}
+
+func (e *eagerDeadlineReader) Read(p []byte) (int, error) {
+	e.mux.Lock()
+	deadline := e.deadline
+	e.mux.Unlock()
+
+	time.Sleep(time.Until(deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestContextReaderDeadlineBeforeContext(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+
+		_, err := NewContextReader(ctx, &eagerDeadlineReader{}).Read(make([]byte, 1))
+		expectInterrupted(t, err, "read", 0, context.DeadlineExceeded)
+
+		cancel()
+	}
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decides when a scheduled task should next run.
+type Schedule interface {
+	// Returns the first time after the given time that the task should run.
+	Next(after time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (i intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(i.interval)
+}
+
+// Returns a Schedule that runs a task every d, measured from when the
+// previous run was scheduled. Like time.NewTicker, it panics if d is not
+// positive.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("utils: non-positive interval for Every")
+	}
+
+	return intervalSchedule{interval: d}
+}
+
+// A Schedule parsed from a standard five-field cron expression.
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// In cron, if both the day of month and day of week are restricted, a
+	// day matching either one counts.
+	daysRestricted, weekdaysRestricted bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Parses a cron expression of the form "minute hour day-of-month month
+// day-of-week". Each field may be "*", a number, a range such as "1-5", a
+// list such as "1,15,30", or any of those followed by a step such as "*/15".
+// Times are evaluated in the location of the time passed to Next.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q has %d fields, expected %d", expr, len(fields), len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+
+		bits[i] = b
+	}
+
+	return &CronSchedule{
+		minutes:            bits[0],
+		hours:              bits[1],
+		days:               bits[2],
+		months:             bits[3],
+		weekdays:           bits[4],
+		daysRestricted:     fields[2] != "*",
+		weekdaysRestricted: fields[4] != "*",
+	}, nil
+}
+
+// Returns the first whole minute after the given time that matches the
+// expression, or the zero time if there isn't one within five years (e.g.
+// "0 0 31 2 *").
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !hasBit(c.months, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !hasBit(c.hours, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !hasBit(c.minutes, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	day := hasBit(c.days, t.Day())
+	weekday := hasBit(c.weekdays, int(t.Weekday()))
+
+	if c.daysRestricted && c.weekdaysRestricted {
+		return day || weekday
+	}
+
+	return day && weekday
+}
+
+func hasBit(bits uint64, n int) bool {
+	return bits&(1<<uint(n)) != 0
+}
+
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, spec.name)
+			}
+			step = s
+		}
+
+		low, high := spec.min, spec.max
+
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			l, err := strconv.Atoi(lowPart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field", lowPart, spec.name)
+			}
+			low, high = l, l
+
+			if isRange {
+				h, err := strconv.Atoi(highPart)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s field", highPart, spec.name)
+				}
+				high = h
+			} else if hasStep {
+				// "5/15" means every 15 starting at 5.
+				high = spec.max
+			}
+		}
+
+		if low < spec.min || high > spec.max || low > high {
+			return 0, fmt.Errorf("%q is out of range for %s field (%d-%d)", part, spec.name, spec.min, spec.max)
+		}
+
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	after := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC)
+
+	testCases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2024, time.January, 31, 10, 8, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2024, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{expr: "5/15 * * * *", want: time.Date(2024, time.January, 31, 10, 20, 0, 0, time.UTC)},
+		{expr: "0 9-17 * * *", want: time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{expr: "30 2 * * *", want: time.Date(2024, time.February, 1, 2, 30, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1,15 * *", want: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		// January 31st 2024 is a Wednesday, so the next Monday is February 5th.
+		{expr: "0 0 * * 1", want: time.Date(2024, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		// When both day fields are restricted, either one matching is enough.
+		{expr: "0 0 10 * 1", want: time.Date(2024, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 2 *", want: time.Time{}},
+	}
+
+	for _, testCase := range testCases {
+		schedule, err := ParseCron(testCase.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", testCase.expr, err)
+			continue
+		}
+
+		if got := schedule.Next(after); !got.Equal(testCase.want) {
+			t.Errorf("%q: expected %s, got %s", testCase.expr, testCase.want, got)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestEveryPanicsOnNonPositiveInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected Every(%s) to panic", d)
+				}
+			}()
+
+			Every(d)
+		}()
+	}
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Returned by Any and Race when they are given no futures, since neither has
+// anything to wait for.
+var ErrNoFutures = errors.New("no futures given")
+
+// A value (or error) that some other Goroutine will produce. This is
+// sendValueOverChannel's sumChan with room for an error and without the need
+// for the receiver to be ready when the value is sent.
+type Future[T any] struct {
+	done chan struct{}
+	once sync.Once
+
+	// Only read once done is closed.
+	value T
+	err   error
+}
+
+// Runs fn in a new Goroutine and returns a Future for its result. A panic in
+// fn becomes a *PanicError.
+func Async[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *Future[T] {
+	f := newFuture[T]()
+
+	go func() {
+		var value T
+		err := callRecovered(func() error {
+			var err error
+			value, err = fn(ctx)
+			return err
+		})
+
+		f.resolve(value, err)
+	}()
+
+	return f
+}
+
+// Returns a Future that has already resolved to the given value and error.
+func Completed[T any](value T, err error) *Future[T] {
+	f := newFuture[T]()
+	f.resolve(value, err)
+	return f
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// Only the first call has any effect.
+func (f *Future[T]) resolve(value T, err error) {
+	f.once.Do(func() {
+		f.value = value
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Returns a channel that is closed once the result is ready.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Waits for the result, or until the context ends, in which case it returns
+// the context's error. The result can be awaited any number of times.
+func (f *Future[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Returns a Future that, once f succeeds, resolves to the result of calling
+// fn with its value. If f fails, fn is not called and the error is passed
+// along. If the context ends before f resolves, the new Future fails with the
+// context's error.
+func Then[T, U any](ctx context.Context, f *Future[T], fn func(ctx context.Context, value T) (U, error)) *Future[U] {
+	return Async(ctx, func(ctx context.Context) (U, error) {
+		value, err := f.Await(ctx)
+		if err != nil {
+			var zero U
+			return zero, err
+		}
+
+		return fn(ctx, value)
+	})
+}
+
+// Like Then, for functions that can't fail.
+func MapFuture[T, U any](ctx context.Context, f *Future[T], fn func(value T) U) *Future[U] {
+	return Then(ctx, f, func(ctx context.Context, value T) (U, error) {
+		return fn(value), nil
+	})
+}
+
+// Returns a Future that resolves to every future's value, in the order given,
+// once they have all succeeded. It fails as soon as any one of them fails
+// (or the context ends) without waiting for the rest.
+func All[T any](ctx context.Context, futures ...*Future[T]) *Future[[]T] {
+	if len(futures) == 0 {
+		return Completed([]T{}, nil)
+	}
+
+	// Since every error wins, this only resolves without one once every
+	// future has succeeded.
+	failed := firstOf(ctx, futures, func(err error) bool {
+		return err != nil
+	})
+
+	return Then(ctx, failed, func(context.Context, T) ([]T, error) {
+		values := make([]T, len(futures))
+		for i, f := range futures {
+			values[i] = f.value
+		}
+
+		return values, nil
+	})
+}
+
+// Returns a Future that resolves to the value of whichever future succeeds
+// first. If they all fail, it fails with a *MultiError of their errors.
+func Any[T any](ctx context.Context, futures ...*Future[T]) *Future[T] {
+	return firstOf(ctx, futures, func(err error) bool {
+		return err == nil
+	})
+}
+
+// Returns a Future that resolves to the result of whichever future finishes
+// first, whether it succeeded or not.
+func Race[T any](ctx context.Context, futures ...*Future[T]) *Future[T] {
+	return firstOf(ctx, futures, func(error) bool {
+		return true
+	})
+}
+
+// Resolves to the first result that wins, or fails with every error if none
+// do.
+func firstOf[T any](ctx context.Context, futures []*Future[T], wins func(err error) bool) *Future[T] {
+	result := newFuture[T]()
+
+	if len(futures) == 0 {
+		var zero T
+		result.resolve(zero, ErrNoFutures)
+		return result
+	}
+
+	go func() {
+		errs := make([]error, len(futures))
+		wg := sync.WaitGroup{}
+
+		for i, f := range futures {
+			i, f := i, f
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				select {
+				case <-f.done:
+				case <-result.done:
+					return
+				}
+
+				if wins(f.err) {
+					result.resolve(f.value, f.err)
+				} else {
+					errs[i] = f.err
+				}
+			}()
+		}
+
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			var zero T
+			result.resolve(zero, ctx.Err())
+		case <-waitGroupDone(&wg):
+			var zero T
+			result.resolve(zero, NewMultiError(errs...))
+		}
+	}()
+
+	return result
+}
+
+func waitGroupDone(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
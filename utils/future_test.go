@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns a Future that resolves to the given value and error once release is
+// closed.
+func pendingFuture[T any](release <-chan struct{}, value T, err error) *Future[T] {
+	return Async(context.Background(), func(context.Context) (T, error) {
+		<-release
+		return value, err
+	})
+}
+
+func awaitWithin[T any](t *testing.T, f *Future[T]) (T, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	value, err := f.Await(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("timed out waiting for the future")
+	}
+
+	return value, err
+}
+
+func TestFutureAsync(t *testing.T) {
+	f := Async(context.Background(), func(context.Context) (int, error) {
+		return 42, nil
+	})
+
+	// The result can be awaited more than once.
+	for i := 0; i < 2; i++ {
+		if value, err := awaitWithin(t, f); value != 42 || err != nil {
+			t.Errorf("expected 42, got %d, %v", value, err)
+		}
+	}
+
+	select {
+	case <-f.Done():
+	default:
+		t.Error("expected Done to be closed once the result is ready")
+	}
+}
+
+func TestFutureAsyncPanic(t *testing.T) {
+	f := Async(context.Background(), func(context.Context) (int, error) {
+		panic("boom")
+	})
+
+	panicErr := &PanicError{}
+	if _, err := awaitWithin(t, f); !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("expected a *PanicError, got %v", err)
+	}
+}
+
+func TestFutureAwaitContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := pendingFuture(release, 1, nil).Await(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFutureThen(t *testing.T) {
+	ctx := context.Background()
+
+	doubled := MapFuture(ctx, Completed(21, nil), func(value int) int {
+		return value * 2
+	})
+
+	formatted := Then(ctx, doubled, func(ctx context.Context, value int) (string, error) {
+		if value != 42 {
+			return "", errDependency
+		}
+
+		return "forty-two", nil
+	})
+
+	if value, err := awaitWithin(t, formatted); value != "forty-two" || err != nil {
+		t.Errorf("expected forty-two, got %q, %v", value, err)
+	}
+
+	called := false
+	failed := Then(ctx, Completed(0, errDependency), func(ctx context.Context, value int) (int, error) {
+		called = true
+		return value, nil
+	})
+
+	if _, err := awaitWithin(t, failed); !errors.Is(err, errDependency) {
+		t.Errorf("expected the error to be passed along, got %v", err)
+	}
+
+	if called {
+		t.Error("expected fn not to be called after a failure")
+	}
+}
+
+func TestFutureAll(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Succeeds", func(t *testing.T) {
+		release := make(chan struct{})
+		all := All(ctx, pendingFuture(release, 1, nil), Completed(2, nil), pendingFuture(release, 3, nil))
+		close(release)
+
+		// Every future resolving without an error must not be mistaken for
+		// them all failing.
+		values, err := awaitWithin(t, all)
+		if err != nil || !reflect.DeepEqual(values, []int{1, 2, 3}) {
+			t.Errorf("expected [1 2 3], got %v, %v", values, err)
+		}
+	})
+
+	t.Run("FailsFast", func(t *testing.T) {
+		never := make(chan struct{})
+		defer close(never)
+
+		all := All(ctx, pendingFuture(never, 1, nil), Completed(0, errDependency))
+
+		if _, err := awaitWithin(t, all); !errors.Is(err, errDependency) {
+			t.Errorf("expected %s, got %v", errDependency, err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		never := make(chan struct{})
+		defer close(never)
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		all := All(cancelCtx, pendingFuture(never, 1, nil))
+		cancel()
+
+		if _, err := awaitWithin(t, all); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		values, err := awaitWithin(t, All[int](ctx))
+		if err != nil || len(values) != 0 {
+			t.Errorf("expected no values, got %v, %v", values, err)
+		}
+	})
+}
+
+func TestFutureAny(t *testing.T) {
+	ctx := context.Background()
+	errOther := errors.New("other failure")
+
+	// The winner is the last to finish, so it races the check for every
+	// future having finished. It must win every time.
+	for i := 0; i < 100; i++ {
+		release := make(chan struct{})
+		f := Any(ctx, Completed(0, errDependency), Completed(0, errOther), pendingFuture(release, 42, nil))
+		close(release)
+
+		if value, err := awaitWithin(t, f); value != 42 || err != nil {
+			t.Fatalf("expected 42, got %d, %v", value, err)
+		}
+	}
+
+	_, err := awaitWithin(t, Any(ctx, Completed(0, errDependency), Completed(0, errOther)))
+
+	multiErr := &MultiError{}
+	if !errors.As(err, &multiErr) || !errors.Is(err, errDependency) || !errors.Is(err, errOther) {
+		t.Errorf("expected a *MultiError of both errors, got %v", err)
+	}
+
+	if _, err := awaitWithin(t, Any[int](ctx)); !errors.Is(err, ErrNoFutures) {
+		t.Errorf("expected ErrNoFutures, got %v", err)
+	}
+}
+
+func TestFutureRace(t *testing.T) {
+	ctx := context.Background()
+	never := make(chan struct{})
+	defer close(never)
+
+	f := Race(ctx, pendingFuture(never, 1, nil), Completed(0, errDependency))
+	if _, err := awaitWithin(t, f); !errors.Is(err, errDependency) {
+		t.Errorf("expected the first result even though it failed, got %v", err)
+	}
+
+	f = Race(ctx, pendingFuture(never, 1, nil), Completed(2, nil))
+	if value, err := awaitWithin(t, f); value != 2 || err != nil {
+		t.Errorf("expected 2, got %d, %v", value, err)
+	}
+
+	if _, err := awaitWithin(t, Race[int](ctx)); !errors.Is(err, ErrNoFutures) {
+		t.Errorf("expected ErrNoFutures, got %v", err)
+	}
+}
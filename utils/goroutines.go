@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A single function call within a Goroutine's stack.
+type StackFrame struct {
+	// The fully-qualified function name, e.g. "main.(*server).handle".
+	Function string
+	// The raw argument list as printed by the runtime, e.g. "0x1, 0x2" or "...".
+	Args string
+	File string
+	Line int
+}
+
+func (s StackFrame) String() string {
+	return fmt.Sprintf("%s at %s:%d", s.Function, s.File, s.Line)
+}
+
+// Everything the runtime tells us about a Goroutine in a stack dump.
+type Goroutine struct {
+	ID int
+	// What the Goroutine is doing, e.g. "running", "chan receive", "select",
+	// "sleep" or "sync.Mutex.Lock".
+	State string
+	// How long the Goroutine has been blocked. The runtime only reports this in
+	// whole minutes once a Goroutine has been waiting for at least a minute.
+	WaitTime       time.Duration
+	LockedToThread bool
+	// The stack, innermost frame first.
+	Stack []StackFrame
+	// Whether the runtime left out some frames because the stack was too deep.
+	FramesElided bool
+	// The frame that started this Goroutine (nil for the main Goroutine) and,
+	// on Go 1.21 and later, the ID of the Goroutine that started it.
+	CreatedBy *StackFrame
+	CreatorID int
+}
+
+// Returns a string that is identical for Goroutines in the same state with
+// the same stack, regardless of their IDs or how long they've been waiting.
+func (g Goroutine) Signature() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[%s]\n", g.State)
+
+	for _, frame := range g.Stack {
+		fmt.Fprintf(b, "%s\n", frame)
+	}
+
+	if g.CreatedBy != nil {
+		fmt.Fprintf(b, "created by %s\n", g.CreatedBy)
+	}
+
+	return b.String()
+}
+
+// A set of Goroutines that share the same state and stack.
+type GoroutineGroup struct {
+	State     string
+	Stack     []StackFrame
+	CreatedBy *StackFrame
+	IDs       []int
+}
+
+// Takes a snapshot of every live Goroutine.
+func CaptureGoroutines() ([]Goroutine, error) {
+	return ParseGoroutines(captureStacks(true))
+}
+
+// Parses the output of runtime.Stack(buf, true) (or the equivalent pprof
+// goroutine profile with debug=2) into one record per Goroutine.
+func ParseGoroutines(dump []byte) ([]Goroutine, error) {
+	goroutines := []Goroutine{}
+	var current *Goroutine
+	var pendingFunc string
+	var pendingArgs string
+	var pendingCreatedBy bool
+	lineNum := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			current = nil
+		case strings.HasPrefix(line, "goroutine "):
+			g, err := parseGoroutineHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			goroutines = append(goroutines, g)
+			current = &goroutines[len(goroutines)-1]
+			pendingFunc = ""
+		case current == nil:
+			return nil, fmt.Errorf("line %d: expected a goroutine header, got %q", lineNum, line)
+		case strings.HasPrefix(line, "\t"):
+			if pendingFunc == "" {
+				return nil, fmt.Errorf("line %d: file location %q has no function", lineNum, line)
+			}
+
+			file, lineNo, err := parseFileLine(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			frame := StackFrame{Function: pendingFunc, Args: pendingArgs, File: file, Line: lineNo}
+			if pendingCreatedBy {
+				current.CreatedBy = &frame
+			} else {
+				current.Stack = append(current.Stack, frame)
+			}
+
+			pendingFunc = ""
+		case strings.HasPrefix(line, "created by "):
+			pendingFunc, current.CreatorID = parseCreatedBy(line)
+			pendingArgs = ""
+			pendingCreatedBy = true
+		case strings.HasPrefix(line, "...") && strings.Contains(line, "frames elided"):
+			current.FramesElided = true
+		default:
+			pendingFunc, pendingArgs = parseFunctionCall(line)
+			pendingCreatedBy = false
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return goroutines, nil
+}
+
+// Returns only the Goroutines for which keep returns true.
+func FilterGoroutines(goroutines []Goroutine, keep func(Goroutine) bool) []Goroutine {
+	filtered := []Goroutine{}
+
+	for _, g := range goroutines {
+		if keep(g) {
+			filtered = append(filtered, g)
+		}
+	}
+
+	return filtered
+}
+
+// Groups Goroutines with the same state and stack together, largest group
+// first. This is similar to what the pprof goroutine profile shows with
+// debug=1.
+func GroupGoroutines(goroutines []Goroutine) []GoroutineGroup {
+	groups := []GoroutineGroup{}
+	indexes := map[string]int{}
+
+	for _, g := range goroutines {
+		sig := g.Signature()
+
+		idx, ok := indexes[sig]
+		if !ok {
+			idx = len(groups)
+			indexes[sig] = idx
+			groups = append(groups, GoroutineGroup{
+				State:     g.State,
+				Stack:     g.Stack,
+				CreatedBy: g.CreatedBy,
+			})
+		}
+
+		groups[idx].IDs = append(groups[idx].IDs, g.ID)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].IDs) > len(groups[j].IDs)
+	})
+
+	return groups
+}
+
+// Calls runtime.Stack, growing the buffer until the whole dump fits.
+func captureStacks(all bool) []byte {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n := runtime.Stack(buf, all)
+		if n < len(buf) {
+			return buf[:n]
+		}
+
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// Parses a line such as "goroutine 6 [chan receive, 2 minutes, locked to thread]:".
+func parseGoroutineHeader(line string) (Goroutine, error) {
+	rest := strings.TrimPrefix(line, "goroutine ")
+
+	idField, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return Goroutine{}, fmt.Errorf("malformed goroutine header %q", line)
+	}
+
+	id, err := strconv.Atoi(idField)
+	if err != nil {
+		return Goroutine{}, fmt.Errorf("malformed goroutine ID in %q: %w", line, err)
+	}
+
+	start := strings.Index(rest, "[")
+	end := strings.LastIndex(rest, "]")
+	if start == -1 || end < start {
+		return Goroutine{}, fmt.Errorf("missing goroutine state in %q", line)
+	}
+
+	g := Goroutine{ID: id}
+
+	for i, field := range strings.Split(rest[start+1:end], ", ") {
+		switch {
+		case i == 0:
+			g.State = field
+		case field == "locked to thread":
+			g.LockedToThread = true
+		case strings.HasSuffix(field, " minutes"):
+			minutes, err := strconv.Atoi(strings.TrimSuffix(field, " minutes"))
+			if err != nil {
+				return Goroutine{}, fmt.Errorf("malformed wait time in %q: %w", line, err)
+			}
+
+			g.WaitTime = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	return g, nil
+}
+
+// Parses a line such as "main.(*T).method(0x1, 0x2)" into the function name
+// and its arguments.
+func parseFunctionCall(line string) (string, string) {
+	if !strings.HasSuffix(line, ")") {
+		return line, ""
+	}
+
+	idx := strings.LastIndex(line, "(")
+	if idx <= 0 {
+		return line, ""
+	}
+
+	return line[:idx], line[idx+1 : len(line)-1]
+}
+
+// Parses a line such as "created by main.main in goroutine 1". Go versions
+// prior to 1.21 do not include the creating Goroutine's ID.
+func parseCreatedBy(line string) (string, int) {
+	rest := strings.TrimPrefix(line, "created by ")
+
+	fn, creator, ok := strings.Cut(rest, " in goroutine ")
+	if !ok {
+		return rest, 0
+	}
+
+	id, err := strconv.Atoi(creator)
+	if err != nil {
+		return fn, 0
+	}
+
+	return fn, id
+}
+
+// Parses a line such as "\t/path/to/file.go:123 +0x1d".
+func parseFileLine(line string) (string, int, error) {
+	loc := strings.TrimPrefix(line, "\t")
+	if idx := strings.LastIndex(loc, " +0x"); idx != -1 {
+		loc = loc[:idx]
+	}
+
+	idx := strings.LastIndex(loc, ":")
+	if idx == -1 {
+		return "", 0, fmt.Errorf("malformed file location %q", line)
+	}
+
+	lineNo, err := strconv.Atoi(loc[idx+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed line number in %q: %w", line, err)
+	}
+
+	return loc[:idx], lineNo, nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// An error returned by a named task in a Group.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (t *TaskError) Error() string {
+	return fmt.Sprintf("task %q: %s", t.Task, t.Err)
+}
+
+func (t *TaskError) Unwrap() error {
+	return t.Err
+}
+
+// Runs a set of tasks in their own Goroutines and collects their errors. The
+// first task to fail cancels the context shared by every task so that the
+// rest can stop early. Unlike a bare WaitGroup, this gives us a way to get
+// errors back out of our Goroutines.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Has room for as many tasks as may run at once; nil if unlimited.
+	slots chan struct{}
+
+	mux  sync.Mutex
+	errs []error
+}
+
+// Creates a new Group whose tasks get a context derived from ctx. If limit is
+// greater than zero, at most that many tasks run at once and Go blocks until
+// one finishes. The returned context is the one the tasks receive.
+func NewGroup(ctx context.Context, limit int) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	g := &Group{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if limit > 0 {
+		g.slots = make(chan struct{}, limit)
+	}
+
+	return g, ctx
+}
+
+// Starts the named task in a new Goroutine. If the task returns an error or
+// panics, the shared context is cancelled and the error is kept for Wait.
+// Tasks started after a failure still run, but with a cancelled context.
+func (g *Group) Go(name string, f func(ctx context.Context) error) {
+	if g.slots != nil {
+		g.slots <- struct{}{}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		if g.slots != nil {
+			defer func() {
+				<-g.slots
+			}()
+		}
+
+		err := callRecovered(func() error {
+			return f(g.ctx)
+		})
+
+		if err != nil {
+			g.mux.Lock()
+			g.errs = append(g.errs, &TaskError{Task: name, Err: err})
+			g.mux.Unlock()
+
+			g.cancel()
+		}
+	}()
+}
+
+// Waits for every task to finish and returns a *MultiError holding each
+// task's *TaskError in the order they failed, or nil if none did. The shared
+// context is cancelled once Wait returns.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return NewMultiError(g.errs...)
+}
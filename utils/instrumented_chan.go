@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// A point-in-time view of an InstrumentedChan.
+type ChanStats struct {
+	Name     string
+	Capacity int
+	// How many items are in the buffer right now.
+	Len int
+	// The most items that have been in the buffer at once.
+	HighWater int
+	Sends     int64
+	Receives  int64
+	// How many sends and receives couldn't happen straight away, and how long
+	// they spent waiting in total.
+	BlockedSends    int64
+	BlockedReceives int64
+	SendBlocked     time.Duration
+	ReceiveBlocked  time.Duration
+	// How many sends and receives waited longer than the SlowThreshold.
+	SlowSends    int64
+	SlowReceives int64
+}
+
+// Configures an InstrumentedChan.
+type InstrumentedChanOptions struct {
+	// Identifies the channel in log lines and stats.
+	Name string
+	// If set, a line is logged when a send or receive has been blocked for
+	// this long, and again when it finally completes. Because the first line
+	// is logged while the operation is still stuck, this also catches
+	// operations that never complete, such as a receive from a channel that
+	// nobody is going to close.
+	SlowThreshold time.Duration
+	// Where slow operations are logged. Defaults to os.Stderr.
+	Output io.Writer
+}
+
+// Wraps a channel to count how much goes through it, how long each side
+// waits for the other, and how full the buffer gets. These are the things
+// we can only guess at in the channel lessons.
+type InstrumentedChan[T any] struct {
+	ch   chan T
+	opts InstrumentedChanOptions
+
+	sends           atomic.Int64
+	receives        atomic.Int64
+	blockedSends    atomic.Int64
+	blockedReceives atomic.Int64
+	sendBlocked     atomic.Int64
+	receiveBlocked  atomic.Int64
+	slowSends       atomic.Int64
+	slowReceives    atomic.Int64
+	highWater       atomic.Int64
+}
+
+// Creates a new InstrumentedChan with the given buffer capacity (0 for
+// unbuffered).
+func NewInstrumentedChan[T any](capacity int, opts InstrumentedChanOptions) *InstrumentedChan[T] {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	return &InstrumentedChan[T]{
+		ch:   make(chan T, capacity),
+		opts: opts,
+	}
+}
+
+// Sends v, waiting until there is room for it or the context ends. Like a
+// regular channel, sending after Close panics.
+func (c *InstrumentedChan[T]) Send(ctx context.Context, v T) error {
+	select {
+	case c.ch <- v:
+		c.sent()
+		return nil
+	default:
+	}
+
+	c.blockedSends.Add(1)
+	start := time.Now()
+	slow, stop := c.slowTimer()
+	defer stop()
+
+	for {
+		select {
+		case c.ch <- v:
+			c.sendBlocked.Add(int64(time.Since(start)))
+			c.unblocked(slow == nil, "send", "completed", start)
+			c.sent()
+			return nil
+		case <-ctx.Done():
+			c.sendBlocked.Add(int64(time.Since(start)))
+			c.unblocked(slow == nil, "send", "gave up", start)
+			return ctx.Err()
+		case <-slow:
+			slow = nil
+			c.slowSends.Add(1)
+			c.logf("%s: send has been blocked in Goroutine %d for %s", c.opts.Name, GetGoroutineID(), time.Since(start))
+		}
+	}
+}
+
+// Receives the next value, waiting until there is one or the context ends.
+// Returns false if the channel has been closed and drained, or if the context
+// ended first; check ctx.Err() to tell which.
+func (c *InstrumentedChan[T]) Receive(ctx context.Context) (T, bool) {
+	select {
+	case v, ok := <-c.ch:
+		c.received(ok)
+		return v, ok
+	default:
+	}
+
+	c.blockedReceives.Add(1)
+	start := time.Now()
+	slow, stop := c.slowTimer()
+	defer stop()
+
+	for {
+		select {
+		case v, ok := <-c.ch:
+			c.receiveBlocked.Add(int64(time.Since(start)))
+			c.unblocked(slow == nil, "receive", "completed", start)
+			c.received(ok)
+			return v, ok
+		case <-ctx.Done():
+			c.receiveBlocked.Add(int64(time.Since(start)))
+			c.unblocked(slow == nil, "receive", "gave up", start)
+			var zero T
+			return zero, false
+		case <-slow:
+			slow = nil
+			c.slowReceives.Add(1)
+			c.logf("%s: receive has been blocked in Goroutine %d for %s", c.opts.Name, GetGoroutineID(), time.Since(start))
+		}
+	}
+}
+
+// Closes the underlying channel. Receivers get whatever is left in the buffer
+// and then false.
+func (c *InstrumentedChan[T]) Close() {
+	close(c.ch)
+}
+
+// Returns how many items are in the buffer.
+func (c *InstrumentedChan[T]) Len() int {
+	return len(c.ch)
+}
+
+// Returns the buffer's capacity.
+func (c *InstrumentedChan[T]) Cap() int {
+	return cap(c.ch)
+}
+
+// Returns the channel's stats so far.
+func (c *InstrumentedChan[T]) Stats() ChanStats {
+	return ChanStats{
+		Name:            c.opts.Name,
+		Capacity:        cap(c.ch),
+		Len:             len(c.ch),
+		HighWater:       int(c.highWater.Load()),
+		Sends:           c.sends.Load(),
+		Receives:        c.receives.Load(),
+		BlockedSends:    c.blockedSends.Load(),
+		BlockedReceives: c.blockedReceives.Load(),
+		SendBlocked:     time.Duration(c.sendBlocked.Load()),
+		ReceiveBlocked:  time.Duration(c.receiveBlocked.Load()),
+		SlowSends:       c.slowSends.Load(),
+		SlowReceives:    c.slowReceives.Load(),
+	}
+}
+
+func (c *InstrumentedChan[T]) sent() {
+	c.sends.Add(1)
+
+	// A receiver may have already taken the value, so this can miss the true
+	// peak by a little, but it never overstates it.
+	depth := int64(len(c.ch))
+	for {
+		high := c.highWater.Load()
+		if depth <= high || c.highWater.CompareAndSwap(high, depth) {
+			return
+		}
+	}
+}
+
+func (c *InstrumentedChan[T]) received(ok bool) {
+	if ok {
+		c.receives.Add(1)
+	}
+}
+
+// Returns a channel that fires once the slow threshold passes, or nil (which
+// never fires) if there isn't one.
+func (c *InstrumentedChan[T]) slowTimer() (<-chan time.Time, func()) {
+	if c.opts.SlowThreshold <= 0 {
+		return nil, func() {}
+	}
+
+	timer := time.NewTimer(c.opts.SlowThreshold)
+	return timer.C, func() {
+		timer.Stop()
+	}
+}
+
+// Logs the end of an operation that we already logged as slow.
+func (c *InstrumentedChan[T]) unblocked(wasSlow bool, op, outcome string, start time.Time) {
+	if wasSlow && c.opts.SlowThreshold > 0 {
+		c.logf("%s: %s %s in Goroutine %d after %s", c.opts.Name, op, outcome, GetGoroutineID(), time.Since(start))
+	}
+}
+
+func (c *InstrumentedChan[T]) logf(format string, args ...interface{}) {
+	fmt.Fprintf(c.opts.Output, format+"\n", args...)
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// How long we wait for Goroutines to exit when LeakOptions.GracePeriod is
+	// not set.
+	DefaultLeakGracePeriod = 100 * time.Millisecond
+
+	leakPollInterval = 5 * time.Millisecond
+)
+
+// Functions belonging to Goroutines that the runtime and standard library
+// start in the background and are never expected to exit. These are always
+// allowed in addition to LeakOptions.Allow.
+var DefaultLeakAllowList = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.ensureSigM",
+	"testing.tRunner",
+	"testing.(*T).Run",
+	"testing.(*F).Fuzz",
+	"runtime/trace.Start",
+}
+
+// Configures how we look for leaked Goroutines.
+type LeakOptions struct {
+	// How long to keep waiting for new Goroutines to exit before calling them
+	// leaked. Defaults to DefaultLeakGracePeriod.
+	GracePeriod time.Duration
+	// Function names (or prefixes thereof, such as "net/http.") of known
+	// background Goroutines. A Goroutine is allowed if any frame in its stack,
+	// or the frame that created it, matches.
+	Allow []string
+}
+
+// Returned when Goroutines are still running after the grace period.
+type LeakError struct {
+	leaked []Goroutine
+}
+
+// Returns the Goroutines that were leaked.
+func (l *LeakError) Leaked() []Goroutine {
+	return l.leaked
+}
+
+func (l *LeakError) Error() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "found %d leaked goroutine(s):", len(l.leaked))
+
+	for _, g := range l.leaked {
+		fmt.Fprintf(b, "\n\ngoroutine %d [%s]:", g.ID, g.State)
+
+		for _, frame := range g.Stack {
+			fmt.Fprintf(b, "\n\t%s", frame)
+		}
+
+		if g.CreatedBy != nil {
+			fmt.Fprintf(b, "\n\tcreated by %s", g.CreatedBy)
+		}
+	}
+
+	return b.String()
+}
+
+// The set of Goroutines that were running at a given point in time.
+type GoroutineSnapshot struct {
+	ids map[int]struct{}
+}
+
+// Records which Goroutines are currently running so that we can later find
+// any that were started after this point.
+func SnapshotGoroutines() (*GoroutineSnapshot, error) {
+	goroutines, err := CaptureGoroutines()
+	if err != nil {
+		return nil, fmt.Errorf("could not snapshot goroutines: %w", err)
+	}
+
+	ids := make(map[int]struct{}, len(goroutines))
+	for _, g := range goroutines {
+		ids[g.ID] = struct{}{}
+	}
+
+	return &GoroutineSnapshot{ids: ids}, nil
+}
+
+// Waits up to the grace period for every Goroutine started since the snapshot
+// was taken to exit. Returns a *LeakError describing any that are left.
+func (g *GoroutineSnapshot) FindLeaks(opts LeakOptions) error {
+	grace := opts.GracePeriod
+	if grace == 0 {
+		grace = DefaultLeakGracePeriod
+	}
+
+	allow := append(append([]string{}, DefaultLeakAllowList...), opts.Allow...)
+	self := GetGoroutineID()
+	deadline := time.Now().Add(grace)
+
+	for {
+		current, err := CaptureGoroutines()
+		if err != nil {
+			return fmt.Errorf("could not capture goroutines: %w", err)
+		}
+
+		leaked := FilterGoroutines(current, func(c Goroutine) bool {
+			if _, ok := g.ids[c.ID]; ok || c.ID == self {
+				return false
+			}
+
+			return !isAllowedGoroutine(c, allow)
+		})
+
+		if len(leaked) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &LeakError{leaked: leaked}
+		}
+
+		time.Sleep(leakPollInterval)
+	}
+}
+
+// Runs the given function and returns a *LeakError if any Goroutines it
+// started are still running once the grace period has passed.
+func CheckForLeaks(opts LeakOptions, f func()) error {
+	snapshot, err := SnapshotGoroutines()
+	if err != nil {
+		return err
+	}
+
+	f()
+
+	return snapshot.FindLeaks(opts)
+}
+
+// The subset of testing.TB that VerifyNoLeaks needs. We use our own interface
+// so that this package does not have to import the testing package.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Snapshots the running Goroutines and returns a function that fails the test
+// if any new ones are still running after the grace period. It's meant to be
+// used at the top of a test like so:
+//
+//	defer utils.VerifyNoLeaks(t, utils.LeakOptions{})()
+func VerifyNoLeaks(t TestingT, opts LeakOptions) func() {
+	t.Helper()
+
+	snapshot, err := SnapshotGoroutines()
+	if err != nil {
+		t.Errorf("%s", err)
+		return func() {}
+	}
+
+	return func() {
+		t.Helper()
+
+		if err := snapshot.FindLeaks(opts); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+}
+
+func isAllowedGoroutine(g Goroutine, allow []string) bool {
+	frames := g.Stack
+	if g.CreatedBy != nil {
+		frames = append(append([]StackFrame{}, frames...), *g.CreatedBy)
+	}
+
+	for _, frame := range frames {
+		for _, prefix := range allow {
+			if strings.HasPrefix(frame.Function, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
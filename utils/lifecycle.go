@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returned (within a *MultiError) by Lifecycle.Shutdown for each hook that
+// did not return before its deadline.
+type HookTimeoutError struct {
+	Hook    string
+	Timeout time.Duration
+}
+
+func (h *HookTimeoutError) Error() string {
+	return fmt.Sprintf("shutdown hook %q did not finish within %s", h.Hook, h.Timeout)
+}
+
+// Configures a Lifecycle.
+type LifecycleOptions struct {
+	// The signals that start a shutdown. Defaults to SIGINT and SIGTERM.
+	Signals []os.Signal
+	// How long each shutdown hook gets unless one is given when registering
+	// it. Defaults to 5s.
+	HookTimeout time.Duration
+	// Called with exit code 1 if a second signal arrives before shutdown has
+	// finished. Defaults to os.Exit; tests can replace it.
+	ForceExit func(code int)
+}
+
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// Ties a program's root context to OS signals. The first signal cancels the
+// context, which is the cue to call Shutdown; a second one exits immediately
+// for when shutdown is stuck.
+type Lifecycle struct {
+	opts LifecycleOptions
+
+	mux      sync.Mutex
+	hooks    []shutdownHook
+	received os.Signal
+
+	sigChan  chan os.Signal
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// Creates a new Lifecycle. Call Start to begin listening for signals.
+func NewLifecycle(opts LifecycleOptions) *Lifecycle {
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	if opts.HookTimeout == 0 {
+		opts.HookTimeout = 5 * time.Second
+	}
+
+	if opts.ForceExit == nil {
+		opts.ForceExit = os.Exit
+	}
+
+	return &Lifecycle{
+		opts:     opts,
+		sigChan:  make(chan os.Signal, 2),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Starts listening for signals and returns a context derived from parent that
+// is cancelled when the first one arrives.
+func (l *Lifecycle) Start(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+
+	signal.Notify(l.sigChan, l.opts.Signals...)
+
+	go func() {
+		defer cancel()
+
+		select {
+		case sig := <-l.sigChan:
+			l.mux.Lock()
+			l.received = sig
+			l.mux.Unlock()
+		case <-l.stopChan:
+			return
+		}
+
+		// Cancel right away rather than waiting for the deferred call, since we
+		// now need to stay around to watch for a second signal.
+		cancel()
+
+		select {
+		case <-l.sigChan:
+			l.opts.ForceExit(1)
+		case <-l.stopChan:
+		}
+	}()
+
+	return ctx
+}
+
+// Returns the signal that started the shutdown, or nil if none has arrived.
+func (l *Lifecycle) Signal() os.Signal {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.received
+}
+
+// Registers a hook to run during Shutdown. Hooks run one at a time in the
+// reverse order they were registered, so things are torn down in the reverse
+// order they were set up. If timeout is zero, LifecycleOptions.HookTimeout is
+// used.
+func (l *Lifecycle) OnShutdown(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	if timeout == 0 {
+		timeout = l.opts.HookTimeout
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, timeout: timeout, fn: fn})
+}
+
+// Runs every registered hook, each with its own deadline. A hook that overruns
+// is abandoned and reported as a *HookTimeoutError; a hook that fails is
+// reported as a *TaskError. Both are returned together in a *MultiError. We
+// stop listening for signals once every hook has run.
+func (l *Lifecycle) Shutdown() error {
+	defer l.Stop()
+
+	l.mux.Lock()
+	hooks := append([]shutdownHook{}, l.hooks...)
+	l.mux.Unlock()
+
+	errs := []error{}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := runShutdownHook(hooks[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return NewMultiError(errs...)
+}
+
+// Stops listening for signals without running any hooks. It is safe to call
+// more than once.
+func (l *Lifecycle) Stop() {
+	l.stopOnce.Do(func() {
+		signal.Stop(l.sigChan)
+		close(l.stopChan)
+	})
+}
+
+func runShutdownHook(hook shutdownHook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+
+	// The hook gets its own Goroutine so that we can move on if it ignores its
+	// context.
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- callRecovered(func() error {
+			return hook.fn(ctx)
+		})
+	}()
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			return nil
+		}
+
+		// A hook that gave up because of its deadline overran just the same.
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			return &HookTimeoutError{Hook: hook.name, Timeout: hook.timeout}
+		}
+
+		return &TaskError{Task: hook.name, Err: err}
+	case <-ctx.Done():
+		return &HookTimeoutError{Hook: hook.name, Timeout: hook.timeout}
+	}
+}
@@ -0,0 +1,166 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Sends the signal to the test binary itself. Its handler must already be
+// registered, since the default action for most signals kills the process.
+func sendSignalToSelf(t *testing.T, sig os.Signal) {
+	t.Helper()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleSignalCancelsContext(t *testing.T) {
+	// We use SIGUSR1 rather than SIGINT so that the test doesn't get mixed up
+	// with someone pressing Ctrl-C. Nothing else handles it, so if the
+	// Lifecycle fails to register for it, the test binary is killed.
+	l := NewLifecycle(LifecycleOptions{Signals: []os.Signal{syscall.SIGUSR1}})
+	defer l.Stop()
+
+	ctx := l.Start(context.Background())
+
+	if l.Signal() != nil {
+		t.Fatalf("expected no signal yet, got %s", l.Signal())
+	}
+
+	sendSignalToSelf(t, syscall.SIGUSR1)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the signal to cancel the context")
+	}
+
+	if l.Signal() != syscall.SIGUSR1 {
+		t.Errorf("expected SIGUSR1, got %v", l.Signal())
+	}
+}
+
+func TestLifecycleSecondSignalForcesExit(t *testing.T) {
+	exitCodes := make(chan int, 1)
+	// SIGUSR1 for the same reasons as in TestLifecycleSignalCancelsContext.
+	l := NewLifecycle(LifecycleOptions{
+		Signals: []os.Signal{syscall.SIGUSR1},
+		ForceExit: func(code int) {
+			exitCodes <- code
+		},
+	})
+	defer l.Stop()
+
+	ctx := l.Start(context.Background())
+
+	sendSignalToSelf(t, syscall.SIGUSR1)
+	<-ctx.Done()
+
+	select {
+	case code := <-exitCodes:
+		t.Fatalf("expected no forced exit after one signal, got exit code %d", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendSignalToSelf(t, syscall.SIGUSR1)
+
+	select {
+	case code := <-exitCodes:
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a second signal to force an exit")
+	}
+}
+
+func TestLifecycleShutdownHooks(t *testing.T) {
+	l := NewLifecycle(LifecycleOptions{HookTimeout: 50 * time.Millisecond})
+	l.Start(context.Background())
+
+	// Each hook runs in its own Goroutine, and one is abandoned while still
+	// running, so they need a lock to share this.
+	mux := sync.Mutex{}
+	ran := []string{}
+	record := func(name string) {
+		mux.Lock()
+		defer mux.Unlock()
+		ran = append(ran, name)
+	}
+
+	errFailed := errors.New("failed")
+
+	l.OnShutdown("first", 0, func(ctx context.Context) error {
+		record("first")
+		return nil
+	})
+	l.OnShutdown("ignores-deadline", 10*time.Millisecond, func(ctx context.Context) error {
+		record("ignores-deadline")
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	l.OnShutdown("honours-deadline", 10*time.Millisecond, func(ctx context.Context) error {
+		record("honours-deadline")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	l.OnShutdown("fails", 0, func(ctx context.Context) error {
+		record("fails")
+		return errFailed
+	})
+	l.OnShutdown("panics", 0, func(ctx context.Context) error {
+		record("panics")
+		panic("oh no")
+	})
+
+	err := l.Shutdown()
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	expectedOrder := []string{"panics", "fails", "honours-deadline", "ignores-deadline", "first"}
+	if !reflect.DeepEqual(ran, expectedOrder) {
+		t.Errorf("expected hooks to run in the order %v, got %v", expectedOrder, ran)
+	}
+
+	multiErr := &MultiError{}
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("expected a *MultiError, got %v", err)
+	}
+
+	errs := multiErr.Errors()
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 errors, got %d: %v", len(errs), err)
+	}
+
+	panicErr := &PanicError{}
+	taskErr := &TaskError{}
+	if !errors.As(errs[0], &taskErr) || taskErr.Task != "panics" || !errors.As(errs[0], &panicErr) {
+		t.Errorf("expected the panic to be reported as a *TaskError holding a *PanicError, got %v", errs[0])
+	}
+
+	if !errors.Is(errs[1], errFailed) || !errors.As(errs[1], &taskErr) || taskErr.Task != "fails" {
+		t.Errorf("expected the failure to be reported as a *TaskError, got %v", errs[1])
+	}
+
+	for i, name := range []string{"honours-deadline", "ignores-deadline"} {
+		timeoutErr := &HookTimeoutError{}
+		if !errors.As(errs[i+2], &timeoutErr) || timeoutErr.Hook != name || timeoutErr.Timeout != 10*time.Millisecond {
+			t.Errorf("expected %s to be reported as a *HookTimeoutError, got %v", name, errs[i+2])
+		}
+	}
+}
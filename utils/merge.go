@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// A value received by Merge along with the index of the channel it came from.
+type Tagged[T any] struct {
+	Source int
+	Value  T
+}
+
+// Fans in any number of channels into one. Each value is tagged with the
+// index of the channel it was read from. The returned channel is closed once
+// every input channel has closed, or as soon as the context ends.
+//
+// This starts one Goroutine per input channel. See MergeSelect for a version
+// that uses a single Goroutine.
+func Merge[T any](ctx context.Context, chans ...<-chan T) <-chan Tagged[T] {
+	out := make(chan Tagged[T])
+	wg := sync.WaitGroup{}
+
+	for i, ch := range chans {
+		i := i
+		ch := ch
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case val, ok := <-ch:
+					if !ok {
+						return
+					}
+
+					select {
+					case out <- Tagged[T]{Source: i, Value: val}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Like Merge, but reads every input channel from a single Goroutine using
+// reflect.Select. This is a runtime-sized version of the select statement in
+// the channels lesson: closed channels are removed from the set of cases
+// instead of being tracked with a boolean per channel.
+func MergeSelect[T any](ctx context.Context, chans ...<-chan T) <-chan Tagged[T] {
+	out := make(chan Tagged[T])
+
+	go func() {
+		defer close(out)
+
+		// The first case is always the context so that cancellation can
+		// interrupt a receive. The case for input i is at index i+1.
+		cases := make([]reflect.SelectCase, len(chans)+1)
+		cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+
+		for i, ch := range chans {
+			cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+		}
+
+		remaining := len(chans)
+
+		for remaining > 0 {
+			chosen, val, ok := reflect.Select(cases)
+			if chosen == 0 {
+				return
+			}
+
+			if !ok {
+				// reflect.Select ignores cases whose channel is the zero Value.
+				cases[chosen].Chan = reflect.Value{}
+				remaining--
+				continue
+			}
+
+			// The comma-ok form gives us the zero value instead of a panic when T
+			// is an interface type and a nil was sent.
+			value, _ := val.Interface().(T)
+
+			select {
+			case out <- Tagged[T]{Source: chosen - 1, Value: value}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
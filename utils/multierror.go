@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// Holds several errors that happened independently of each other, such as
+// those returned by different Goroutines. Go 1.19 does not have errors.Join,
+// so we implement Is and As ourselves to let errors.Is and errors.As look
+// inside each one.
+type MultiError struct {
+	errs []error
+}
+
+// Returns a *MultiError holding the given errors with any nils removed. If no
+// errors are left, it returns nil so that it can be returned directly.
+func NewMultiError(errs ...error) error {
+	nonNil := []error{}
+
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+
+	if len(nonNil) == 0 {
+		return nil
+	}
+
+	return &MultiError{errs: nonNil}
+}
+
+// Returns each of the errors held by this MultiError.
+func (m *MultiError) Errors() []error {
+	return append([]error{}, m.errs...)
+}
+
+func (m *MultiError) Error() string {
+	if len(m.errs) == 1 {
+		return m.errs[0].Error()
+	}
+
+	msgs := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Reports whether any of the held errors matches the target.
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds the first held error that matches the target.
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
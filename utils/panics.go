@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Holds a value recovered from a panic along with the stack at the time it
+// happened. An unrecovered panic in any Goroutine crashes the whole program,
+// so the helpers in this package that run user code in their own Goroutines
+// turn panics into one of these instead.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// Allows errors.Is and errors.As to see the panic value if it was an error.
+func (p *PanicError) Unwrap() error {
+	if err, ok := p.Value.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// Calls f and returns its error, or a *PanicError if it panicked.
+func callRecovered(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return f()
+}
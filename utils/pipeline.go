@@ -0,0 +1,342 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A point-in-time view of how a single pipeline stage is doing. Comparing the
+// blocked times of neighbouring stages shows where the bottleneck is: a slow
+// stage has upstream stages blocked sending to it and downstream stages
+// blocked receiving from it.
+type StageMetrics struct {
+	Name    string
+	Workers int
+	In      int64
+	// Values emitted to the next stage. For Reduce, values folded in.
+	Out    int64
+	Errors int64
+	// Total time, across every worker, spent in the stage's function.
+	Busy time.Duration
+	// Total time, across every worker, spent waiting for input.
+	BlockedReceiving time.Duration
+	// Total time, across every worker, spent waiting for the next stage to
+	// take our output.
+	BlockedSending time.Duration
+	// Items emitted per second since the stage started.
+	Throughput float64
+}
+
+type stageStats struct {
+	name    string
+	workers int
+	started time.Time
+
+	in               atomic.Int64
+	out              atomic.Int64
+	errors           atomic.Int64
+	busy             atomic.Int64
+	blockedReceiving atomic.Int64
+	blockedSending   atomic.Int64
+}
+
+func (s *stageStats) snapshot() StageMetrics {
+	m := StageMetrics{
+		Name:             s.name,
+		Workers:          s.workers,
+		In:               s.in.Load(),
+		Out:              s.out.Load(),
+		Errors:           s.errors.Load(),
+		Busy:             time.Duration(s.busy.Load()),
+		BlockedReceiving: time.Duration(s.blockedReceiving.Load()),
+		BlockedSending:   time.Duration(s.blockedSending.Load()),
+	}
+
+	if elapsed := time.Since(s.started).Seconds(); elapsed > 0 {
+		m.Throughput = float64(m.Out) / elapsed
+	}
+
+	return m
+}
+
+// Ties a series of stages together so that they share a context, the first
+// error from any stage cancels the rest, and every stage's Goroutines have
+// exited by the time Wait returns. This is the generator / summer pair from
+// iteratingOverChannels, generalized.
+type Pipeline struct {
+	parent context.Context
+	ctx    context.Context
+	group  *Group
+
+	mux    sync.Mutex
+	stages []*stageStats
+}
+
+// Creates a new Pipeline whose stages stop when ctx ends.
+func NewPipeline(ctx context.Context) *Pipeline {
+	group, groupCtx := NewGroup(ctx, 0)
+
+	return &Pipeline{
+		parent: ctx,
+		ctx:    groupCtx,
+		group:  group,
+	}
+}
+
+// Returns the metrics for every stage in the order they were added.
+func (p *Pipeline) Metrics() []StageMetrics {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	metrics := make([]StageMetrics, len(p.stages))
+	for i, stage := range p.stages {
+		metrics[i] = stage.snapshot()
+	}
+
+	return metrics
+}
+
+// Waits for every stage to finish. Returns a *MultiError of the stages'
+// *TaskErrors if any failed, otherwise the parent context's error if it
+// ended early, otherwise nil. Any output that hasn't been read must be
+// drained (or the context cancelled) for this to return.
+func (p *Pipeline) Wait() error {
+	if err := p.group.Wait(); err != nil {
+		return err
+	}
+
+	return p.parent.Err()
+}
+
+// Registers a stage and starts workers Goroutines that each run fn. The last
+// of them to return calls closeFunc, so that it is done by the time Wait
+// returns.
+func (p *Pipeline) addStage(name string, workers int, fn func(stats *stageStats) error, closeFunc func()) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	stats := &stageStats{name: name, workers: workers, started: time.Now()}
+
+	p.mux.Lock()
+	p.stages = append(p.stages, stats)
+	p.mux.Unlock()
+
+	remaining := &atomic.Int64{}
+	remaining.Store(int64(workers))
+
+	for i := 0; i < workers; i++ {
+		p.group.Go(name, func(ctx context.Context) error {
+			defer func() {
+				if remaining.Add(-1) == 0 {
+					closeFunc()
+				}
+			}()
+
+			return fn(stats)
+		})
+	}
+}
+
+// Starts a stage that produces values by calling emit. Emit returns false once
+// the pipeline is shutting down, at which point fn should return.
+func Generate[T any](p *Pipeline, name string, fn func(ctx context.Context, emit func(T) bool) error) <-chan T {
+	out := make(chan T)
+
+	p.addStage(name, 1, func(stats *stageStats) error {
+		err := fn(p.ctx, func(v T) bool {
+			return pipelineSend(p.ctx, stats, out, v)
+		})
+
+		return stageError(p.ctx, stats, err)
+	}, func() {
+		close(out)
+	})
+
+	return out
+}
+
+// Starts a stage that transforms each value with fn using the given number of
+// workers. With more than one worker, the output order is not guaranteed.
+func Map[In, Out any](p *Pipeline, name string, workers int, in <-chan In, fn func(context.Context, In) (Out, error)) <-chan Out {
+	return FlatMap(p, name, workers, in, func(ctx context.Context, v In) ([]Out, error) {
+		mapped, err := fn(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+
+		return []Out{mapped}, nil
+	})
+}
+
+// Starts a stage that only passes on values for which fn returns true.
+func Filter[T any](p *Pipeline, name string, workers int, in <-chan T, fn func(context.Context, T) (bool, error)) <-chan T {
+	return FlatMap(p, name, workers, in, func(ctx context.Context, v T) ([]T, error) {
+		keep, err := fn(ctx, v)
+		if err != nil || !keep {
+			return nil, err
+		}
+
+		return []T{v}, nil
+	})
+}
+
+// Starts a stage that turns each value into zero or more values.
+func FlatMap[In, Out any](p *Pipeline, name string, workers int, in <-chan In, fn func(context.Context, In) ([]Out, error)) <-chan Out {
+	out := make(chan Out)
+
+	p.addStage(name, workers, func(stats *stageStats) error {
+		for {
+			v, ok := pipelineReceive(p.ctx, stats, in)
+			if !ok {
+				return nil
+			}
+
+			start := time.Now()
+			results, err := fn(p.ctx, v)
+			stats.busy.Add(int64(time.Since(start)))
+
+			if err != nil {
+				return stageError(p.ctx, stats, err)
+			}
+
+			for _, result := range results {
+				if !pipelineSend(p.ctx, stats, out, result) {
+					return nil
+				}
+			}
+		}
+	}, func() {
+		close(out)
+	})
+
+	return out
+}
+
+// Starts a stage that groups values into slices of the given size. The final
+// batch may be smaller.
+func Batch[T any](p *Pipeline, name string, size int, in <-chan T) <-chan []T {
+	out := make(chan []T)
+
+	if size < 1 {
+		size = 1
+	}
+
+	p.addStage(name, 1, func(stats *stageStats) error {
+		batch := make([]T, 0, size)
+
+		for {
+			v, ok := pipelineReceive(p.ctx, stats, in)
+			if !ok {
+				break
+			}
+
+			batch = append(batch, v)
+			if len(batch) < size {
+				continue
+			}
+
+			if !pipelineSend(p.ctx, stats, out, batch) {
+				return nil
+			}
+
+			batch = make([]T, 0, size)
+		}
+
+		if len(batch) > 0 && p.ctx.Err() == nil {
+			pipelineSend(p.ctx, stats, out, batch)
+		}
+
+		return nil
+	}, func() {
+		close(out)
+	})
+
+	return out
+}
+
+// Folds every value from in into a single result. It blocks until the whole
+// pipeline has finished and returns the same error as Wait.
+func Reduce[T, Acc any](p *Pipeline, name string, in <-chan T, initial Acc, fn func(context.Context, Acc, T) (Acc, error)) (Acc, error) {
+	acc := initial
+	done := make(chan struct{})
+
+	p.addStage(name, 1, func(stats *stageStats) error {
+		for {
+			v, ok := pipelineReceive(p.ctx, stats, in)
+			if !ok {
+				return nil
+			}
+
+			start := time.Now()
+			next, err := fn(p.ctx, acc, v)
+			stats.busy.Add(int64(time.Since(start)))
+
+			if err != nil {
+				return stageError(p.ctx, stats, err)
+			}
+
+			acc = next
+			stats.out.Add(1)
+		}
+	}, func() {
+		close(done)
+	})
+
+	<-done
+
+	if err := p.Wait(); err != nil {
+		var zero Acc
+		return zero, err
+	}
+
+	return acc, nil
+}
+
+func pipelineReceive[T any](ctx context.Context, stats *stageStats, in <-chan T) (T, bool) {
+	start := time.Now()
+	defer func() {
+		stats.blockedReceiving.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case v, ok := <-in:
+		if ok {
+			stats.in.Add(1)
+		}
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+func pipelineSend[T any](ctx context.Context, stats *stageStats, out chan<- T, v T) bool {
+	start := time.Now()
+	defer func() {
+		stats.blockedSending.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case out <- v:
+		stats.out.Add(1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Counts the error, unless it is only the pipeline's own cancellation being
+// passed back to us, in which case we drop it so that Wait reports the error
+// that actually caused the shutdown.
+func stageError(ctx context.Context, stats *stageStats, err error) error {
+	if err == nil || (ctx.Err() != nil && errors.Is(err, ctx.Err())) {
+		return nil
+	}
+
+	stats.errors.Add(1)
+	return err
+}
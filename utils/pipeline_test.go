@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func generateInts(p *Pipeline, n int) <-chan int {
+	return Generate(p, "generate", func(ctx context.Context, emit func(int) bool) error {
+		for i := 1; i <= n; i++ {
+			if !emit(i) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	// Every stage's Goroutines, including whichever one closes its output,
+	// must be gone as soon as Reduce returns, so there is no grace period.
+	defer VerifyNoLeaks(t, LeakOptions{GracePeriod: time.Nanosecond})()
+
+	p := NewPipeline(context.Background())
+
+	nums := generateInts(p, 100)
+	squares := Map(p, "square", 4, nums, func(ctx context.Context, n int) (int, error) {
+		return n * n, nil
+	})
+	even := Filter(p, "even", 2, squares, func(ctx context.Context, n int) (bool, error) {
+		return n%2 == 0, nil
+	})
+	batches := Batch(p, "batch", 7, even)
+
+	total, err := Reduce(p, "sum", batches, 0, func(ctx context.Context, acc int, batch []int) (int, error) {
+		for _, n := range batch {
+			acc += n
+		}
+		return acc, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The sum of the squares of the even numbers up to 100.
+	if total != 171700 {
+		t.Errorf("expected 171700, got %d", total)
+	}
+
+	metrics := p.Metrics()
+	if len(metrics) != 5 {
+		t.Fatalf("expected 5 stages, got %d", len(metrics))
+	}
+
+	expectedOut := map[string]int64{"generate": 100, "square": 100, "even": 50, "batch": 8, "sum": 8}
+	for _, m := range metrics {
+		if m.Out != expectedOut[m.Name] {
+			t.Errorf("%s: expected %d out, got %d", m.Name, expectedOut[m.Name], m.Out)
+		}
+
+		if m.Throughput <= 0 {
+			t.Errorf("%s: expected a positive throughput", m.Name)
+		}
+	}
+}
+
+func TestPipelineStageError(t *testing.T) {
+	defer VerifyNoLeaks(t, LeakOptions{GracePeriod: time.Nanosecond})()
+
+	errBoom := errors.New("boom")
+	p := NewPipeline(context.Background())
+
+	// The generator never stops on its own, so this only returns because the
+	// failing stage cancels it.
+	nums := Generate(p, "forever", func(ctx context.Context, emit func(int) bool) error {
+		for i := 0; emit(i); i++ {
+		}
+		return ctx.Err()
+	})
+	failing := Map(p, "fail", 3, nums, func(ctx context.Context, n int) (int, error) {
+		if n == 10 {
+			return 0, errBoom
+		}
+		return n, nil
+	})
+
+	_, err := Reduce(p, "count", failing, 0, func(ctx context.Context, acc, n int) (int, error) {
+		return acc + 1, nil
+	})
+
+	taskErr := &TaskError{}
+	if !errors.Is(err, errBoom) || !errors.As(err, &taskErr) || taskErr.Task != "fail" {
+		t.Fatalf("expected the fail stage's error, got %v", err)
+	}
+
+	for _, m := range p.Metrics() {
+		if m.Name == "forever" && m.Errors != 0 {
+			t.Errorf("expected the cancelled generator not to count an error, got %d", m.Errors)
+		}
+	}
+}
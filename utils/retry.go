@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// The reason a *RetryError gives when every attempt was used up.
+	ErrRetriesExhausted = errors.New("retries exhausted")
+	// The reason a *RetryError gives when an attempt ran past
+	// RetryOptions.MaxElapsed, or waiting for another one would.
+	ErrRetryBudgetExhausted = errors.New("retry time budget exhausted")
+	// The reason a *RetryError gives when the classifier said an error should
+	// not be retried.
+	ErrNotRetryable = errors.New("error is not retryable")
+)
+
+// How the delay between attempts is randomized. Jitter keeps a crowd of
+// clients that failed at the same moment from all retrying at the same moment.
+// See: https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type JitterStrategy int
+
+const (
+	// Use the exponential delay as-is.
+	NoJitter JitterStrategy = iota
+	// Pick a random delay between zero and the exponential delay.
+	FullJitter
+	// Pick a random delay between InitialDelay and three times the previous
+	// delay, capped at MaxDelay.
+	DecorrelatedJitter
+)
+
+// Configures Retry. The zero value makes up to 3 attempts, 10ms and then 20ms
+// apart, and retries anything but the context ending.
+type RetryOptions struct {
+	// The most times the function is called. Defaults to 3. A negative number
+	// means we keep going until MaxElapsed or the context stops us.
+	MaxAttempts int
+	// The delay before the second attempt. Defaults to 10ms.
+	InitialDelay time.Duration
+	// The longest we wait between attempts. Defaults to 1s.
+	MaxDelay time.Duration
+	// How much the delay grows after each attempt. Defaults to 2.
+	Multiplier float64
+	Jitter     JitterStrategy
+	// If set, the total time we spend, including the attempts themselves.
+	// Each attempt's context has a deadline this long after the first started,
+	// and we give up rather than wait for an attempt that would start after
+	// it.
+	MaxElapsed time.Duration
+	// Decides whether an error is worth retrying. Defaults to RetryUnlessIs
+	// with context.Canceled and context.DeadlineExceeded.
+	Retryable func(error) bool
+	// Where the jitter comes from. Defaults to DefaultRandomGenerator.
+	Random *RandomGenerator
+}
+
+// Returned by Retry when it gives up. It holds the error from every attempt,
+// and errors.Is and errors.As match against any of them as well as Reason.
+type RetryError struct {
+	// Why we stopped: ErrRetriesExhausted, ErrRetryBudgetExhausted,
+	// ErrNotRetryable or the context's error.
+	Reason error
+	Errs   []error
+}
+
+func (r *RetryError) Error() string {
+	if len(r.Errs) == 0 {
+		return fmt.Sprintf("%s before the first attempt", r.Reason)
+	}
+
+	msgs := make([]string, len(r.Errs))
+	for i, err := range r.Errs {
+		msgs[i] = fmt.Sprintf("attempt %d: %s", i+1, err)
+	}
+
+	return fmt.Sprintf("%s after %d attempt(s): %s", r.Reason, len(r.Errs), strings.Join(msgs, "; "))
+}
+
+// Returns the error from the final attempt.
+func (r *RetryError) Last() error {
+	if len(r.Errs) == 0 {
+		return nil
+	}
+
+	return r.Errs[len(r.Errs)-1]
+}
+
+func (r *RetryError) Is(target error) bool {
+	if errors.Is(r.Reason, target) {
+		return true
+	}
+
+	for _, err := range r.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds the most recent attempt's error that matches the target.
+func (r *RetryError) As(target interface{}) bool {
+	for i := len(r.Errs) - 1; i >= 0; i-- {
+		if errors.As(r.Errs[i], target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns a classifier that only retries errors matching one of the targets.
+func RetryIfIs(targets ...error) func(error) bool {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Returns a classifier that retries every error except those matching one of
+// the targets.
+func RetryUnlessIs(targets ...error) func(error) bool {
+	retryIf := RetryIfIs(targets...)
+	return func(err error) bool {
+		return !retryIf(err)
+	}
+}
+
+// Returns a classifier that only retries errors with an E somewhere in their
+// chain, such as RetryIfAs[*fs.PathError]().
+func RetryIfAs[E error]() func(error) bool {
+	return func(err error) bool {
+		var target E
+		return errors.As(err, &target)
+	}
+}
+
+// Calls fn until it succeeds, the classifier says to stop, we run out of
+// attempts or time, or the context ends. Waiting between attempts stops as
+// soon as the context is cancelled.
+func Retry(ctx context.Context, opts RetryOptions, fn func(ctx context.Context) error) error {
+	_, err := RetryWithValue(ctx, opts, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+
+	return err
+}
+
+// Like Retry, but for functions that also return a value.
+func RetryWithValue[T any](ctx context.Context, opts RetryOptions, fn func(ctx context.Context) (T, error)) (T, error) {
+	opts = retryDefaults(opts)
+
+	var zero T
+	start := time.Now()
+	errs := []error{}
+	delay := time.Duration(0)
+
+	attemptCtx := ctx
+	if opts.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithDeadline(ctx, start.Add(opts.MaxElapsed))
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return zero, &RetryError{Reason: err, Errs: errs}
+		}
+
+		val, err := fn(attemptCtx)
+		if err == nil {
+			return val, nil
+		}
+
+		errs = append(errs, err)
+
+		// The attempt may have failed because it ran out of budget, which the
+		// classifier can't tell apart from the caller's own deadline.
+		if ctx.Err() == nil && attemptCtx.Err() != nil {
+			return zero, &RetryError{Reason: ErrRetryBudgetExhausted, Errs: errs}
+		}
+
+		if !opts.Retryable(err) {
+			return zero, &RetryError{Reason: ErrNotRetryable, Errs: errs}
+		}
+
+		if opts.MaxAttempts > 0 && attempt >= opts.MaxAttempts {
+			return zero, &RetryError{Reason: ErrRetriesExhausted, Errs: errs}
+		}
+
+		delay = nextRetryDelay(opts, attempt, delay)
+
+		if opts.MaxElapsed > 0 && time.Since(start)+delay > opts.MaxElapsed {
+			return zero, &RetryError{Reason: ErrRetryBudgetExhausted, Errs: errs}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, &RetryError{Reason: ctx.Err(), Errs: errs}
+		}
+	}
+}
+
+func retryDefaults(opts RetryOptions) RetryOptions {
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 3
+	}
+
+	if opts.InitialDelay == 0 {
+		opts.InitialDelay = 10 * time.Millisecond
+	}
+
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = time.Second
+	}
+
+	if opts.Multiplier == 0 {
+		opts.Multiplier = 2
+	}
+
+	if opts.Retryable == nil {
+		opts.Retryable = RetryUnlessIs(context.Canceled, context.DeadlineExceeded)
+	}
+
+	if opts.Random == nil {
+		opts.Random = DefaultRandomGenerator
+	}
+
+	return opts
+}
+
+// Works out how long to wait after the given attempt, which starts at one.
+func nextRetryDelay(opts RetryOptions, attempt int, previous time.Duration) time.Duration {
+	if opts.Jitter == DecorrelatedJitter {
+		if previous == 0 {
+			previous = opts.InitialDelay
+		}
+
+		upper := previous * 3
+		if upper > opts.MaxDelay {
+			upper = opts.MaxDelay
+		}
+
+		if upper <= opts.InitialDelay {
+			return upper
+		}
+
+		return opts.InitialDelay + time.Duration(opts.Random.Int(0, int(upper-opts.InitialDelay)))
+	}
+
+	delay := float64(opts.InitialDelay)
+	for i := 1; i < attempt && delay < float64(opts.MaxDelay); i++ {
+		delay *= opts.Multiplier
+	}
+
+	capped := time.Duration(delay)
+	if capped > opts.MaxDelay {
+		capped = opts.MaxDelay
+	}
+
+	if opts.Jitter == FullJitter {
+		return time.Duration(opts.Random.Int(0, int(capped)))
+	}
+
+	return capped
+}
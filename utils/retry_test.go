@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetrySucceedsEventually(t *testing.T) {
+	attempts := 0
+
+	val, err := RetryWithValue(context.Background(), RetryOptions{InitialDelay: time.Millisecond}, func(ctx context.Context) (int, error) {
+		attempts++
+		if attempts < 3 {
+			return 0, errDependency
+		}
+
+		return 42, nil
+	})
+
+	if err != nil || val != 42 || attempts != 3 {
+		t.Errorf("expected 42 after 3 attempts, got %d, %v after %d", val, err, attempts)
+	}
+}
+
+func TestRetryGivingUp(t *testing.T) {
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name             string
+		ctx              context.Context
+		opts             RetryOptions
+		fn               func(ctx context.Context) error
+		expectedReason   error
+		expectedAttempts int
+	}{
+		{
+			name: "Exhausted",
+			ctx:  context.Background(),
+			opts: RetryOptions{MaxAttempts: 4, InitialDelay: time.Millisecond},
+			fn: func(context.Context) error {
+				return errDependency
+			},
+			expectedReason:   ErrRetriesExhausted,
+			expectedAttempts: 4,
+		},
+		{
+			name: "NotRetryable",
+			ctx:  context.Background(),
+			opts: RetryOptions{InitialDelay: time.Millisecond, Retryable: RetryUnlessIs(errDependency)},
+			fn: func(context.Context) error {
+				return errDependency
+			},
+			expectedReason:   ErrNotRetryable,
+			expectedAttempts: 1,
+		},
+		{
+			name: "Cancelled",
+			ctx:  cancelledCtx,
+			opts: RetryOptions{},
+			fn: func(context.Context) error {
+				return errDependency
+			},
+			expectedReason:   context.Canceled,
+			expectedAttempts: 0,
+		},
+		{
+			name: "BudgetTooShortToWait",
+			ctx:  context.Background(),
+			opts: RetryOptions{MaxAttempts: -1, InitialDelay: time.Hour, MaxDelay: time.Hour, MaxElapsed: time.Minute},
+			fn: func(context.Context) error {
+				return errDependency
+			},
+			expectedReason:   ErrRetryBudgetExhausted,
+			expectedAttempts: 1,
+		},
+		{
+			// The attempt never returns on its own, so only the budget can
+			// stop it.
+			name: "AttemptRunsPastBudget",
+			ctx:  context.Background(),
+			opts: RetryOptions{MaxAttempts: -1, MaxElapsed: 10 * time.Millisecond},
+			fn: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			expectedReason:   ErrRetryBudgetExhausted,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			attempts := 0
+			err := Retry(testCase.ctx, testCase.opts, func(ctx context.Context) error {
+				attempts++
+				return testCase.fn(ctx)
+			})
+
+			retryErr := &RetryError{}
+			if !errors.As(err, &retryErr) {
+				t.Fatalf("expected a *RetryError, got %v", err)
+			}
+
+			if retryErr.Reason != testCase.expectedReason {
+				t.Errorf("expected reason %s, got %s", testCase.expectedReason, retryErr.Reason)
+			}
+
+			if attempts != testCase.expectedAttempts || len(retryErr.Errs) != testCase.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d with %d errors", testCase.expectedAttempts, attempts, len(retryErr.Errs))
+			}
+		})
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	first := &fs.PathError{Op: "open", Path: "first", Err: fs.ErrNotExist}
+	last := &fs.PathError{Op: "open", Path: "last", Err: fs.ErrPermission}
+
+	err := error(&RetryError{Reason: ErrRetriesExhausted, Errs: []error{first, errDependency, last}})
+
+	for _, target := range []error{ErrRetriesExhausted, fs.ErrNotExist, errDependency, fs.ErrPermission} {
+		if !errors.Is(err, target) {
+			t.Errorf("expected the error to match %s", target)
+		}
+	}
+
+	if errors.Is(err, ErrNotRetryable) {
+		t.Error("expected the error not to match ErrNotRetryable")
+	}
+
+	pathErr := &fs.PathError{}
+	if !errors.As(err, &pathErr) || pathErr.Path != "last" {
+		t.Errorf("expected As to find the most recent *fs.PathError, got %v", pathErr)
+	}
+
+	if err.(*RetryError).Last() != last {
+		t.Errorf("expected Last to return the final error")
+	}
+
+	msg := err.Error()
+	for _, part := range []string{"retries exhausted after 3 attempt(s)", "attempt 1: open first", "attempt 3: open last"} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("expected %q to contain %q", msg, part)
+		}
+	}
+}
+
+func TestRetryClassifiers(t *testing.T) {
+	pathErr := &fs.PathError{Op: "open", Path: "file", Err: fs.ErrNotExist}
+
+	testCases := []struct {
+		name      string
+		retryable func(error) bool
+		err       error
+		expected  bool
+	}{
+		{name: "IfIsMatch", retryable: RetryIfIs(fs.ErrNotExist), err: pathErr, expected: true},
+		{name: "IfIsNoMatch", retryable: RetryIfIs(fs.ErrPermission), err: pathErr, expected: false},
+		{name: "UnlessIsMatch", retryable: RetryUnlessIs(fs.ErrNotExist), err: pathErr, expected: false},
+		{name: "UnlessIsNoMatch", retryable: RetryUnlessIs(fs.ErrPermission), err: pathErr, expected: true},
+		{name: "IfAsMatch", retryable: RetryIfAs[*fs.PathError](), err: pathErr, expected: true},
+		{name: "IfAsNoMatch", retryable: RetryIfAs[*fs.PathError](), err: errDependency, expected: false},
+	}
+
+	for _, testCase := range testCases {
+		if got := testCase.retryable(testCase.err); got != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, got)
+		}
+	}
+}
+
+func TestRetryDelays(t *testing.T) {
+	opts := retryDefaults(RetryOptions{InitialDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Random: NewRandomGenerator(1)})
+
+	expected := []time.Duration{10, 20, 40, 80, 100, 100}
+	for i, want := range expected {
+		if got := nextRetryDelay(opts, i+1, 0); got != want*time.Millisecond {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, want*time.Millisecond, got)
+		}
+	}
+
+	opts.Jitter = FullJitter
+	for i := 0; i < 1000; i++ {
+		attempt := i%6 + 1
+		if got := nextRetryDelay(opts, attempt, 0); got < 0 || got > expected[attempt-1]*time.Millisecond {
+			t.Fatalf("attempt %d: full jitter delay %s out of range", attempt, got)
+		}
+	}
+
+	opts.Jitter = DecorrelatedJitter
+	previous := time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		upper := 3 * previous
+		if previous == 0 {
+			upper = 3 * opts.InitialDelay
+		}
+
+		if upper > opts.MaxDelay {
+			upper = opts.MaxDelay
+		}
+
+		got := nextRetryDelay(opts, i+1, previous)
+		if got < opts.InitialDelay || got > upper {
+			t.Fatalf("decorrelated jitter delay %s out of range [%s, %s]", got, opts.InitialDelay, upper)
+		}
+
+		previous = got
+	}
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Returned by Scheduler.Add when a task with the same name already exists.
+var ErrDuplicateTask = errors.New("task already scheduled")
+
+// What a Scheduler does when a task comes due while its previous run is still
+// going.
+type OverlapPolicy int
+
+const (
+	// Don't run this time; wait for the next scheduled time instead.
+	SkipIfRunning OverlapPolicy = iota
+	// Run again as soon as the current run finishes. At most one run is
+	// queued; further overlaps while one is queued are skipped.
+	QueueIfRunning
+)
+
+// Configures a single scheduled task.
+type TaskOptions struct {
+	Overlap OverlapPolicy
+	// If set, each run is delayed by a random amount up to this long so that
+	// tasks sharing a schedule don't all fire at once.
+	Jitter time.Duration
+	// How many past runs to remember. Defaults to 10.
+	HistorySize int
+}
+
+// The record of a single run of a task.
+type TaskRun struct {
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// The state of a scheduled task at a point in time.
+type TaskStatus struct {
+	Name    string
+	Running bool
+	// The number of runs started and the number skipped due to overlap.
+	Runs    int
+	Skipped int
+	// When the task is next due, or the zero time if it isn't.
+	NextRun time.Time
+	// The most recent runs, oldest first.
+	History []TaskRun
+}
+
+// Returns the most recent completed run, if there has been one.
+func (t TaskStatus) LastRun() (TaskRun, bool) {
+	if len(t.History) == 0 {
+		return TaskRun{}, false
+	}
+
+	return t.History[len(t.History)-1], true
+}
+
+type scheduledTask struct {
+	name     string
+	schedule Schedule
+	fn       func(ctx context.Context) error
+	opts     TaskOptions
+
+	// The fields below are guarded by Scheduler.mux.
+	running bool
+	queued  bool
+	runs    int
+	skipped int
+	nextRun time.Time
+	history []TaskRun
+}
+
+// Runs named tasks on a schedule until its context is cancelled. Compare this
+// with shuttingDownAGoroutine in the channels lesson, which polls with a
+// sleep in a select default case and is stopped by a single send.
+type Scheduler struct {
+	mux     sync.Mutex
+	tasks   map[string]*scheduledTask
+	started bool
+	// Tracks in-flight runs so that Run can wait for them.
+	runs sync.WaitGroup
+}
+
+// Creates a new Scheduler with no tasks.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		tasks: map[string]*scheduledTask{},
+	}
+}
+
+// Adds a task. Tasks must be added before Run is called.
+func (s *Scheduler) Add(name string, schedule Schedule, fn func(ctx context.Context) error, opts TaskOptions) error {
+	if opts.HistorySize == 0 {
+		opts.HistorySize = 10
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.started {
+		return fmt.Errorf("cannot add task %q: scheduler is already running", name)
+	}
+
+	if _, ok := s.tasks[name]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateTask, name)
+	}
+
+	s.tasks[name] = &scheduledTask{
+		name:     name,
+		schedule: schedule,
+		fn:       fn,
+		opts:     opts,
+	}
+
+	return nil
+}
+
+// Runs every task on its schedule until the context is cancelled, then waits
+// for any in-flight runs to finish. Runs get the same context, so they should
+// return promptly once it ends.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mux.Lock()
+	s.started = true
+	tasks := make([]*scheduledTask, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	s.mux.Unlock()
+
+	wg := sync.WaitGroup{}
+
+	for _, task := range tasks {
+		task := task
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, task)
+		}()
+	}
+
+	wg.Wait()
+	s.runs.Wait()
+}
+
+// Returns the status of every task, sorted by name.
+func (s *Scheduler) Status() []TaskStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		statuses = append(statuses, TaskStatus{
+			Name:    task.name,
+			Running: task.running,
+			Runs:    task.runs,
+			Skipped: task.skipped,
+			NextRun: task.nextRun,
+			History: append([]TaskRun{}, task.history...),
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+func (s *Scheduler) loop(ctx context.Context, task *scheduledTask) {
+	due := time.Now()
+
+	for {
+		due = task.schedule.Next(due)
+		if due.IsZero() {
+			return
+		}
+
+		// Jitter only moves this run. The next one is still worked out from
+		// due, so that it doesn't add to the interval.
+		fireAt := due
+		if task.opts.Jitter > 0 {
+			fireAt = fireAt.Add(time.Duration(DefaultRandomGenerator.Int(0, int(task.opts.Jitter))))
+		}
+
+		s.mux.Lock()
+		task.nextRun = fireAt
+		s.mux.Unlock()
+
+		timer := time.NewTimer(time.Until(fireAt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			s.mux.Lock()
+			task.nextRun = time.Time{}
+			s.mux.Unlock()
+
+			return
+		}
+
+		s.trigger(ctx, task)
+
+		// If we fell more than a whole period behind (say, a clock jump),
+		// don't try to catch up on every missed time at once.
+		if now := time.Now(); task.schedule.Next(due).Before(now) {
+			due = now
+		}
+	}
+}
+
+// Starts a run of the task, unless one is in progress, in which case the
+// task's overlap policy applies.
+func (s *Scheduler) trigger(ctx context.Context, task *scheduledTask) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if task.running {
+		if task.opts.Overlap == QueueIfRunning && !task.queued {
+			task.queued = true
+		} else {
+			task.skipped++
+		}
+
+		return
+	}
+
+	s.startLocked(ctx, task)
+}
+
+// Must be called with s.mux held.
+func (s *Scheduler) startLocked(ctx context.Context, task *scheduledTask) {
+	task.running = true
+	task.runs++
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+
+		start := time.Now()
+		err := callRecovered(func() error {
+			return task.fn(ctx)
+		})
+
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		task.history = append(task.history, TaskRun{Start: start, Duration: time.Since(start), Err: err})
+		if len(task.history) > task.opts.HistorySize {
+			task.history = task.history[len(task.history)-task.opts.HistorySize:]
+		}
+
+		task.running = false
+
+		if task.queued {
+			task.queued = false
+			if ctx.Err() == nil {
+				s.startLocked(ctx, task)
+			}
+		}
+	}()
+}
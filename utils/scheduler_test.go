@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runScheduler(s *Scheduler, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	s.Run(ctx)
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	s := NewScheduler()
+	noop := func(context.Context) error { return nil }
+
+	if err := s.Add("task", Every(time.Hour), noop, TaskOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := s.Add("task", Every(time.Hour), noop, TaskOptions{}); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("expected ErrDuplicateTask, got %v", err)
+	}
+
+	runScheduler(s, time.Millisecond)
+
+	if err := s.Add("other", Every(time.Hour), noop, TaskOptions{}); err == nil {
+		t.Fatal("expected an error adding a task after Run")
+	}
+}
+
+func TestSchedulerEveryDoesNotDrift(t *testing.T) {
+	s := NewScheduler()
+	runs := atomic.Int64{}
+
+	err := s.Add("jittery", Every(20*time.Millisecond), func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}, TaskOptions{Jitter: 15 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScheduler(s, 500*time.Millisecond)
+
+	// 25 runs are due in 500ms. Jitter delays each run but must not stretch
+	// the interval, which would leave us with closer to 18.
+	if got := runs.Load(); got < 22 || got > 25 {
+		t.Errorf("expected about 25 runs, got %d", got)
+	}
+}
+
+func TestSchedulerSkipIfRunning(t *testing.T) {
+	s := NewScheduler()
+
+	err := s.Add("slow", Every(10*time.Millisecond), func(ctx context.Context) error {
+		select {
+		case <-time.After(45 * time.Millisecond):
+		case <-ctx.Done():
+		}
+		return nil
+	}, TaskOptions{Overlap: SkipIfRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScheduler(s, 200*time.Millisecond)
+
+	status := s.Status()[0]
+	if status.Running {
+		t.Error("expected no runs in progress once Run has returned")
+	}
+
+	if status.Skipped == 0 {
+		t.Error("expected some runs to be skipped")
+	}
+
+	if status.Runs > 5 {
+		t.Errorf("expected at most 5 runs, got %d", status.Runs)
+	}
+}
+
+func TestSchedulerQueueIfRunning(t *testing.T) {
+	s := NewScheduler()
+	started := make(chan time.Time, 10)
+
+	err := s.Add("slow", Every(30*time.Millisecond), func(ctx context.Context) error {
+		started <- time.Now()
+		if len(started) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil
+	}, TaskOptions{Overlap: QueueIfRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScheduler(s, 150*time.Millisecond)
+	close(started)
+
+	first := <-started
+	second, ok := <-started
+	if !ok {
+		t.Fatal("expected a queued run")
+	}
+
+	// The queued run starts as soon as the first one finishes rather than
+	// waiting for the next scheduled time.
+	if gap := second.Sub(first); gap < 90*time.Millisecond || gap > 120*time.Millisecond {
+		t.Errorf("expected the queued run to start about 100ms after the first, got %s", gap)
+	}
+
+	// Two overlapping times came up while the first run was going, but only
+	// one can be queued.
+	if skipped := s.Status()[0].Skipped; skipped == 0 {
+		t.Error("expected further overlaps to be skipped while one is queued")
+	}
+}
+
+func TestSchedulerHistory(t *testing.T) {
+	s := NewScheduler()
+	runs := atomic.Int64{}
+
+	err := s.Add("flaky", Every(5*time.Millisecond), func(context.Context) error {
+		if runs.Add(1)%2 == 0 {
+			panic("even run")
+		}
+		return nil
+	}, TaskOptions{HistorySize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScheduler(s, 100*time.Millisecond)
+
+	status := s.Status()[0]
+	if len(status.History) != 3 {
+		t.Fatalf("expected 3 runs of history, got %d", len(status.History))
+	}
+
+	panicErr := &PanicError{}
+	for _, run := range status.History {
+		if run.Err != nil && !errors.As(run.Err, &panicErr) {
+			t.Errorf("expected panics to be recorded as *PanicError, got %v", run.Err)
+		}
+	}
+
+	last, ok := status.LastRun()
+	if !ok || last != status.History[2] {
+		t.Errorf("expected LastRun to return the newest run")
+	}
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Returned by Semaphore.Acquire when asking for more units than the
+// semaphore has in total, since waiting would never help.
+var ErrWeightExceedsCapacity = errors.New("weight exceeds semaphore capacity")
+
+// A point-in-time view of a Semaphore.
+type SemaphoreStats struct {
+	Capacity int64
+	InUse    int64
+	// How many callers are waiting right now.
+	Waiters int
+	// How many acquisitions have succeeded, and how many of those had to wait.
+	Acquired  int64
+	Contended int64
+	// How many waits ended with the context before the units were available.
+	Cancelled int64
+	// The total and longest time spent waiting, including waits that were
+	// cancelled.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// Limits how much of something is in use at once. Where the WaitGroups in the
+// Goroutine lessons only wait for work to finish, this stops more than a set
+// amount of it from starting. Each caller asks for a number of units, so a
+// big job can take more of the capacity than a small one.
+//
+// Waiters are served in the order they arrived. A large request at the front
+// of the queue holds up smaller ones behind it, even if they would fit, so
+// that it isn't starved by a steady stream of small ones.
+type Semaphore struct {
+	capacity int64
+
+	mux     sync.Mutex
+	inUse   int64
+	waiters list.List
+	stats   SemaphoreStats
+}
+
+// Creates a new Semaphore with the given number of units.
+func NewSemaphore(capacity int64) *Semaphore {
+	return &Semaphore{capacity: capacity}
+}
+
+// Takes n units, waiting until they are available or the context ends. On
+// success, Release(n) must be called once they are no longer needed.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if n > s.capacity {
+		return fmt.Errorf("%w: asked for %d of %d", ErrWeightExceedsCapacity, n, s.capacity)
+	}
+
+	s.mux.Lock()
+	if s.inUse+n <= s.capacity && s.waiters.Len() == 0 {
+		s.inUse += n
+		s.stats.Acquired++
+		s.mux.Unlock()
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		s.mux.Unlock()
+		return err
+	}
+
+	w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mux.Unlock()
+
+	start := time.Now()
+
+	select {
+	case <-w.ready:
+		s.mux.Lock()
+		s.recordWait(time.Since(start))
+		s.stats.Acquired++
+		s.stats.Contended++
+		s.mux.Unlock()
+
+		return nil
+	case <-ctx.Done():
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		s.recordWait(time.Since(start))
+		s.stats.Cancelled++
+
+		select {
+		case <-w.ready:
+			// We were handed the units just as the context ended. Since we're
+			// reporting failure, give them back.
+			s.inUse -= n
+		default:
+			s.waiters.Remove(elem)
+		}
+
+		// If we were at the front, the ones behind us may fit now.
+		s.notifyWaiters()
+
+		return ctx.Err()
+	}
+}
+
+// Takes n units if they are available right now and nobody is already
+// waiting for them. Reports whether it did.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.inUse+n > s.capacity || s.waiters.Len() > 0 {
+		return false
+	}
+
+	s.inUse += n
+	s.stats.Acquired++
+
+	return true
+}
+
+// Gives back n units. Like a negative WaitGroup counter, releasing more than
+// is held is a bug, so it panics.
+func (s *Semaphore) Release(n int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.inUse -= n
+	if s.inUse < 0 {
+		panic("utils: Semaphore released more units than were held")
+	}
+
+	s.notifyWaiters()
+}
+
+// Runs fn while holding n units, releasing them when it returns (or panics).
+// If the units can't be acquired, fn is not called and the error is returned.
+func (s *Semaphore) Do(ctx context.Context, n int64, fn func(ctx context.Context) error) error {
+	if err := s.Acquire(ctx, n); err != nil {
+		return err
+	}
+	defer s.Release(n)
+
+	return fn(ctx)
+}
+
+// Returns the semaphore's stats so far.
+func (s *Semaphore) Stats() SemaphoreStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	stats := s.stats
+	stats.Capacity = s.capacity
+	stats.InUse = s.inUse
+	stats.Waiters = s.waiters.Len()
+
+	return stats
+}
+
+// Hands units to waiters, in order, for as long as the one at the front fits.
+// Must be called with s.mux held.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(*semaphoreWaiter)
+		if s.inUse+w.n > s.capacity {
+			return
+		}
+
+		s.inUse += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// Must be called with s.mux held.
+func (s *Semaphore) recordWait(d time.Duration) {
+	s.stats.TotalWait += d
+	if d > s.stats.MaxWait {
+		s.stats.MaxWait = d
+	}
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Waits until the semaphore has the given number of waiters, so that tests
+// can queue callers up in a known order.
+func waitForWaiters(t *testing.T, s *Semaphore, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().Waiters != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, got %d", n, s.Stats().Waiters)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSemaphoreLimitsConcurrency(t *testing.T) {
+	s := NewSemaphore(3)
+	current := atomic.Int64{}
+	peak := atomic.Int64{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.Do(context.Background(), 1, func(ctx context.Context) error {
+				n := current.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+
+				time.Sleep(2 * time.Millisecond)
+				current.Add(-1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if peak.Load() > 3 {
+		t.Errorf("expected at most 3 at once, got %d", peak.Load())
+	}
+
+	stats := s.Stats()
+	if stats.Acquired != 20 || stats.InUse != 0 || stats.Waiters != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if stats.Contended == 0 || stats.TotalWait == 0 || stats.MaxWait == 0 {
+		t.Errorf("expected some contention to be recorded, got %+v", stats)
+	}
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	s := NewSemaphore(5)
+	if !s.TryAcquire(5) {
+		t.Fatal("expected to acquire every unit")
+	}
+
+	mux := sync.Mutex{}
+	order := []int64{}
+	wg := sync.WaitGroup{}
+
+	// A large request queued ahead of small ones must not be overtaken by
+	// them, even though they would fit sooner.
+	for i, n := range []int64{3, 1, 1} {
+		n := n
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := s.Acquire(context.Background(), n); err != nil {
+				t.Error(err)
+				return
+			}
+
+			mux.Lock()
+			order = append(order, n)
+			mux.Unlock()
+		}()
+
+		waitForWaiters(t, s, i+1)
+	}
+
+	if s.TryAcquire(1) {
+		t.Error("expected TryAcquire not to jump the queue")
+	}
+
+	s.Release(2)
+	time.Sleep(10 * time.Millisecond)
+
+	mux.Lock()
+	if len(order) != 0 {
+		t.Errorf("expected nobody to get in ahead of the large request, got %v", order)
+	}
+	mux.Unlock()
+
+	// Now the large request fits, but the small ones behind it don't.
+	s.Release(1)
+	waitForWaiters(t, s, 2)
+	for {
+		mux.Lock()
+		served := len(order)
+		mux.Unlock()
+
+		if served == 1 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Release(2)
+	wg.Wait()
+
+	if !reflect.DeepEqual(order, []int64{3, 1, 1}) {
+		t.Errorf("expected waiters to be served in order, got %v", order)
+	}
+}
+
+func TestSemaphoreCancelledWaiterUnblocksOthers(t *testing.T) {
+	s := NewSemaphore(2)
+	s.TryAcquire(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bigErr := make(chan error, 1)
+	go func() {
+		bigErr <- s.Acquire(ctx, 2)
+	}()
+	waitForWaiters(t, s, 1)
+
+	smallErr := make(chan error, 1)
+	go func() {
+		smallErr <- s.Acquire(context.Background(), 1)
+	}()
+	waitForWaiters(t, s, 2)
+
+	// Once the large request at the front gives up, the small one behind it
+	// fits.
+	cancel()
+
+	if err := <-bigErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	select {
+	case err := <-smallErr:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the small request to be served")
+	}
+
+	stats := s.Stats()
+	if stats.InUse != 2 || stats.Cancelled != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSemaphoreErrors(t *testing.T) {
+	s := NewSemaphore(2)
+
+	if err := s.Acquire(context.Background(), 3); !errors.Is(err, ErrWeightExceedsCapacity) {
+		t.Errorf("expected ErrWeightExceedsCapacity, got %v", err)
+	}
+
+	s.TryAcquire(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.Acquire(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	called := false
+	err := s.Do(ctx, 1, func(context.Context) error {
+		called = true
+		return nil
+	})
+	if called || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Do not to call fn, got called=%v err=%v", called, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected releasing too much to panic")
+		}
+	}()
+
+	s.Release(3)
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A context that carries its parent's values but not its cancellation or
+// deadline. Go 1.21 has context.WithoutCancel for this, but we target 1.19.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+type singleflightCall[T any] struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// How many callers are still waiting. Guarded by Singleflight.mux.
+	waiters int
+
+	// Only read once done is closed.
+	value T
+	err   error
+}
+
+// Coalesces concurrent calls for the same key into a single execution whose
+// result they all share. If a dozen Goroutines ask for the same file at once,
+// it gets read and parsed once instead of a dozen times.
+//
+// The execution doesn't belong to any one caller, so it runs with a context
+// that carries the first caller's values but is only cancelled once every
+// caller waiting on it has given up.
+type Singleflight[T any] struct {
+	mux   sync.Mutex
+	calls map[string]*singleflightCall[T]
+}
+
+// Creates a new, empty Singleflight.
+func NewSingleflight[T any]() *Singleflight[T] {
+	return &Singleflight[T]{
+		calls: map[string]*singleflightCall[T]{},
+	}
+}
+
+// Calls fn for the key, unless a call for it is already in flight, in which
+// case it waits for and returns that call's result instead. If the context
+// ends first, it returns the context's error; the call carries on for anyone
+// else still waiting. A panic in fn is returned to every caller as a
+// *PanicError.
+func (s *Singleflight[T]) Do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	s.mux.Lock()
+	call, ok := s.calls[key]
+	if ok {
+		call.waiters++
+	} else {
+		call = s.start(ctx, key, fn)
+	}
+	s.mux.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+	}
+
+	s.mux.Lock()
+	call.waiters--
+	if call.waiters == 0 {
+		// Nobody wants the result any more, so stop working on it. Anyone who
+		// asks for the key from now on gets a fresh call rather than joining
+		// one that is being cancelled.
+		call.cancel()
+		if s.calls[key] == call {
+			delete(s.calls, key)
+		}
+	}
+	s.mux.Unlock()
+
+	var zero T
+	return zero, ctx.Err()
+}
+
+// Forgets any in-flight call for the key, so that the next caller starts a
+// new one instead of joining it. Callers already waiting still get the old
+// call's result.
+func (s *Singleflight[T]) Forget(key string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.calls, key)
+}
+
+// Must be called with s.mux held.
+func (s *Singleflight[T]) start(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) *singleflightCall[T] {
+	callCtx, cancel := context.WithCancel(detachedContext{parent: ctx})
+
+	call := &singleflightCall[T]{
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		waiters: 1,
+	}
+	s.calls[key] = call
+
+	go func() {
+		defer cancel()
+
+		call.err = callRecovered(func() error {
+			var err error
+			call.value, err = fn(callCtx)
+			return err
+		})
+
+		s.mux.Lock()
+		if s.calls[key] == call {
+			delete(s.calls, key)
+		}
+		s.mux.Unlock()
+
+		close(call.done)
+	}()
+
+	return call
+}
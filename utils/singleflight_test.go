@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type singleflightResult struct {
+	value int
+	err   error
+}
+
+// Starts a call to Do in its own Goroutine.
+func doAsync(s *Singleflight[int], ctx context.Context, key string, fn func(ctx context.Context) (int, error)) <-chan singleflightResult {
+	resultChan := make(chan singleflightResult, 1)
+
+	go func() {
+		value, err := s.Do(ctx, key, fn)
+		resultChan <- singleflightResult{value: value, err: err}
+	}()
+
+	return resultChan
+}
+
+func waitForSingleflightWaiters(t *testing.T, s *Singleflight[int], key string, n int) {
+	t.Helper()
+
+	waitUntil(t, func() bool {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		call, ok := s.calls[key]
+		return ok && call.waiters == n
+	})
+}
+
+func TestSingleflightSharesOneExecution(t *testing.T) {
+	s := NewSingleflight[int]()
+	calls := atomic.Int64{}
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	results := []<-chan singleflightResult{}
+	for i := 0; i < 10; i++ {
+		results = append(results, doAsync(s, context.Background(), "key", fn))
+	}
+
+	waitForSingleflightWaiters(t, s, "key", 10)
+	close(release)
+
+	for _, resultChan := range results {
+		if result := <-resultChan; result.value != 42 || result.err != nil {
+			t.Errorf("expected 42, got %d, %v", result.value, result.err)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected fn to be called once, got %d", calls.Load())
+	}
+}
+
+func TestSingleflightCancelsOnceEveryCallerGivesUp(t *testing.T) {
+	s := NewSingleflight[int]()
+	cancelled := make(chan struct{})
+
+	fn := func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	result1 := doAsync(s, ctx1, "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 1)
+	result2 := doAsync(s, ctx2, "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 2)
+
+	cancel1()
+	if result := <-result1; !errors.Is(result.err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", result.err)
+	}
+
+	select {
+	case <-cancelled:
+		t.Fatal("expected the call to keep going while someone is still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	if result := <-result2; !errors.Is(result.err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", result.err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to be cancelled once nobody was waiting")
+	}
+}
+
+func TestSingleflightLateCallerGetsResult(t *testing.T) {
+	s := NewSingleflight[int]()
+	calls := atomic.Int64{}
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	result1 := doAsync(s, ctx1, "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 1)
+	result2 := doAsync(s, context.Background(), "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 2)
+
+	// The caller that started the call gives up, but the call carries on for
+	// the other one, and a caller that arrives afterwards joins it.
+	cancel1()
+	if result := <-result1; !errors.Is(result.err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", result.err)
+	}
+
+	result3 := doAsync(s, context.Background(), "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 2)
+	close(release)
+
+	for _, resultChan := range []<-chan singleflightResult{result2, result3} {
+		if result := <-resultChan; result.value != 42 || result.err != nil {
+			t.Errorf("expected 42, got %d, %v", result.value, result.err)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected fn to be called once, got %d", calls.Load())
+	}
+}
+
+func TestSingleflightForget(t *testing.T) {
+	s := NewSingleflight[int]()
+	calls := atomic.Int64{}
+	releases := []chan struct{}{make(chan struct{}), make(chan struct{})}
+
+	fn := func(ctx context.Context) (int, error) {
+		n := calls.Add(1)
+		<-releases[n-1]
+		return int(n), nil
+	}
+
+	old1 := doAsync(s, context.Background(), "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 1)
+	old2 := doAsync(s, context.Background(), "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 2)
+
+	s.Forget("key")
+
+	fresh := doAsync(s, context.Background(), "key", fn)
+	waitForSingleflightWaiters(t, s, "key", 1)
+
+	close(releases[1])
+	if result := <-fresh; result.value != 2 || result.err != nil {
+		t.Errorf("expected the fresh call's result, got %d, %v", result.value, result.err)
+	}
+
+	close(releases[0])
+	for _, resultChan := range []<-chan singleflightResult{old1, old2} {
+		if result := <-resultChan; result.value != 1 || result.err != nil {
+			t.Errorf("expected the old call's result, got %d, %v", result.value, result.err)
+		}
+	}
+}
+
+func TestSingleflightPanic(t *testing.T) {
+	s := NewSingleflight[int]()
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (int, error) {
+		<-release
+		panic("boom")
+	}
+
+	results := []<-chan singleflightResult{
+		doAsync(s, context.Background(), "key", fn),
+	}
+	waitForSingleflightWaiters(t, s, "key", 1)
+	results = append(results, doAsync(s, context.Background(), "key", fn))
+	waitForSingleflightWaiters(t, s, "key", 2)
+
+	close(release)
+
+	for _, resultChan := range results {
+		panicErr := &PanicError{}
+		if result := <-resultChan; !errors.As(result.err, &panicErr) {
+			t.Errorf("expected a *PanicError, got %v", result.err)
+		}
+	}
+}
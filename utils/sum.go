@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Returned (wrapped) by the Sum functions when the result does not fit in the
+// type being summed.
+var ErrSumOverflow = errors.New("sum overflowed")
+
+// Any integer type, including named types built on top of them.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// Any floating point type, including named types built on top of them.
+type Float interface {
+	~float32 | ~float64
+}
+
+// Anything that Sum can add up.
+type Number interface {
+	Integer | Float
+}
+
+// Sums a slice of numbers. If the running total overflows at any point, an
+// error wrapping ErrSumOverflow is returned along with the total up to that
+// point. For floats, overflow means the total became infinite even though
+// none of the inputs were.
+func Sum[T Number](nums []T) (T, error) {
+	var sum T
+
+	for i, num := range nums {
+		next, err := checkedAdd(sum, num)
+		if err != nil {
+			return sum, fmt.Errorf("at index %d: %w", i, err)
+		}
+
+		sum = next
+	}
+
+	return sum, nil
+}
+
+// Sums a slice of floats using Neumaier's variant of Kahan summation, which
+// keeps track of the low-order bits lost by each addition. This is much more
+// accurate than Sum when adding many values of very different magnitudes.
+func SumCompensated[T Float](nums []T) (T, error) {
+	var sum, compensation float64
+	// Once an input is infinite, the compensation becomes NaN and the total
+	// is infinite (or NaN) no matter what, just as it would be with Sum.
+	sawInf := false
+
+	for i, num := range nums {
+		n := float64(num)
+		if math.IsInf(n, 0) {
+			sawInf = true
+		}
+
+		next := sum + n
+
+		if math.Abs(sum) >= math.Abs(n) {
+			compensation += (sum - next) + n
+		} else {
+			compensation += (n - next) + sum
+		}
+
+		sum = next
+
+		if !sawInf && math.IsInf(float64(T(sum)), 0) {
+			return T(sum), fmt.Errorf("at index %d: %w", i, ErrSumOverflow)
+		}
+	}
+
+	if sawInf {
+		return T(sum), nil
+	}
+
+	return T(sum + compensation), nil
+}
+
+// Like Sum, but splits the slice into one chunk per worker, sums each chunk in
+// its own Goroutine, and then merges the partial sums. If workers is less than
+// one, GOMAXPROCS is used. Because the additions happen in a different order,
+// an overflow that Sum would report partway through may not occur here (and
+// vice versa for floats).
+func SumParallel[T Number](nums []T, workers int) (T, error) {
+	return sumParallel(nums, workers, Sum[T])
+}
+
+// Like SumCompensated, but sums each chunk in its own Goroutine. The partial
+// sums are also merged with compensated summation.
+func SumCompensatedParallel[T Float](nums []T, workers int) (T, error) {
+	return sumParallel(nums, workers, SumCompensated[T])
+}
+
+func sumParallel[T Number](nums []T, workers int, sumFunc func([]T) (T, error)) (T, error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > len(nums) {
+		workers = len(nums)
+	}
+
+	if workers <= 1 {
+		return sumFunc(nums)
+	}
+
+	// Rounding the chunk size up can leave fewer chunks than workers (5 items
+	// across 4 workers is 3 chunks of 2), so we only start as many as needed.
+	chunkSize := (len(nums) + workers - 1) / workers
+	workers = (len(nums) + chunkSize - 1) / chunkSize
+	partials := make([]T, workers)
+	errs := make([]error, workers)
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(nums) {
+			end = len(nums)
+		}
+
+		i := i
+		chunk := nums[start:end]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partials[i], errs[i] = sumFunc(chunk)
+		}()
+	}
+
+	wg.Wait()
+
+	var zero T
+	for i, err := range errs {
+		if err != nil {
+			return zero, fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+
+	sum, err := sumFunc(partials)
+	if err != nil {
+		return zero, fmt.Errorf("merging partial sums: %w", err)
+	}
+
+	return sum, nil
+}
+
+// Adds two numbers, returning ErrSumOverflow if the result wrapped around (for
+// integers) or became infinite (for floats).
+func checkedAdd[T Number](a, b T) (T, error) {
+	sum := a + b
+
+	if isFloat[T]() {
+		if math.IsInf(float64(sum), 0) && !math.IsInf(float64(a), 0) && !math.IsInf(float64(b), 0) {
+			return a, ErrSumOverflow
+		}
+
+		return sum, nil
+	}
+
+	// For integers, adding a positive number must make the result larger and
+	// adding a negative one must make it smaller. If not, we've wrapped around.
+	// The b < 0 check is always false for unsigned types.
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return a, ErrSumOverflow
+	}
+
+	return sum, nil
+}
+
+func isFloat[T Number]() bool {
+	// Dividing one by two truncates to zero for integers but not for floats.
+	var one T = 1
+	return one/2 != 0
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSum(t *testing.T) {
+	testCases := []struct {
+		name    string
+		nums    []int8
+		want    int8
+		wantErr error
+	}{
+		{name: "empty", nums: nil, want: 0},
+		{name: "positive", nums: []int8{1, 2, 3}, want: 6},
+		{name: "mixed", nums: []int8{-100, 50, 27}, want: -23},
+		{name: "overflow", nums: []int8{100, 27, 1}, want: 127, wantErr: ErrSumOverflow},
+		{name: "underflow", nums: []int8{-100, -28, -1}, want: -128, wantErr: ErrSumOverflow},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := Sum(testCase.nums)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("expected error %v, got %v", testCase.wantErr, err)
+			}
+
+			if got != testCase.want {
+				t.Errorf("expected %d, got %d", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestSumCompensated(t *testing.T) {
+	testCases := []struct {
+		name    string
+		nums    []float64
+		want    float64
+		wantErr error
+	}{
+		{name: "empty", nums: nil, want: 0},
+		// Plain summation loses the 1s entirely.
+		{name: "cancellation", nums: []float64{1, 1e100, 1, -1e100}, want: 2},
+		{name: "positive infinity input", nums: []float64{math.Inf(1), 1}, want: math.Inf(1)},
+		{name: "negative infinity input", nums: []float64{1, math.Inf(-1), 2}, want: math.Inf(-1)},
+		{name: "overflow", nums: []float64{math.MaxFloat64, math.MaxFloat64}, want: math.Inf(1), wantErr: ErrSumOverflow},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := SumCompensated(testCase.nums)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("expected error %v, got %v", testCase.wantErr, err)
+			}
+
+			if got != testCase.want {
+				t.Errorf("expected %v, got %v", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestSumParallel(t *testing.T) {
+	testCases := []struct {
+		length  int
+		workers int
+	}{
+		{length: 0, workers: 4},
+		{length: 1, workers: 4},
+		{length: 5, workers: 4},
+		{length: 7, workers: 3},
+		{length: 10, workers: 4},
+		{length: 12, workers: 4},
+		{length: 100, workers: 7},
+		{length: 101, workers: 0},
+	}
+
+	for _, testCase := range testCases {
+		nums := make([]int, testCase.length)
+		want := 0
+		for i := range nums {
+			nums[i] = i + 1
+			want += i + 1
+		}
+
+		got, err := SumParallel(nums, testCase.workers)
+		if err != nil {
+			t.Errorf("length %d, %d workers: unexpected error: %s", testCase.length, testCase.workers, err)
+		}
+
+		if got != want {
+			t.Errorf("length %d, %d workers: expected %d, got %d", testCase.length, testCase.workers, want, got)
+		}
+
+		floats := make([]float64, testCase.length)
+		for i := range floats {
+			floats[i] = float64(nums[i])
+		}
+
+		gotFloat, err := SumCompensatedParallel(floats, testCase.workers)
+		if err != nil {
+			t.Errorf("length %d, %d workers: unexpected error: %s", testCase.length, testCase.workers, err)
+		}
+
+		if gotFloat != float64(want) {
+			t.Errorf("length %d, %d workers: expected %d, got %v", testCase.length, testCase.workers, want, gotFloat)
+		}
+	}
+}
+
+func TestSumParallelOverflow(t *testing.T) {
+	if _, err := SumParallel([]int8{100, 100, 100, 100, 100}, 4); !errors.Is(err, ErrSumOverflow) {
+		t.Errorf("expected ErrSumOverflow, got %v", err)
+	}
+}
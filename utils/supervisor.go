@@ -0,0 +1,419 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Returned (wrapped in a *RestartIntensityError) by Supervisor.Run when
+// children are failing faster than the supervisor is allowed to restart them.
+var ErrRestartIntensityExceeded = errors.New("restart intensity exceeded")
+
+// Returned by a child that exits while the supervisor is still running, but
+// without an error. Since children are meant to run until they're cancelled,
+// this still counts as a failure.
+var ErrChildExited = errors.New("child exited unexpectedly")
+
+// Which children get restarted when one of them fails. These are modelled on
+// Erlang/OTP supervisors. See: https://www.erlang.org/doc/design_principles/sup_princ.html
+type RestartStrategy int
+
+const (
+	// Only the failed child is restarted.
+	OneForOne RestartStrategy = iota
+	// Every child is stopped and restarted.
+	OneForAll
+	// The failed child and every child started after it are restarted.
+	RestForOne
+)
+
+func (r RestartStrategy) String() string {
+	switch r {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	case RestForOne:
+		return "rest-for-one"
+	default:
+		return fmt.Sprintf("RestartStrategy(%d)", int(r))
+	}
+}
+
+// Describes a long-running process for a Supervisor to look after.
+type ChildSpec struct {
+	Name string
+	// Should run until the context is cancelled. Returning (or panicking)
+	// before then is treated as a failure.
+	Run func(ctx context.Context) error
+	// How long to wait for Run to return after its context is cancelled.
+	// Defaults to SupervisorOptions.ShutdownTimeout.
+	ShutdownTimeout time.Duration
+}
+
+// Configures a Supervisor. The zero value restarts children one-for-one.
+type SupervisorOptions struct {
+	Strategy RestartStrategy
+	// If there are more than MaxRestarts restarts within Period, the supervisor
+	// gives up, stops every child and returns an error. Defaults to 3 in 5s.
+	MaxRestarts int
+	Period      time.Duration
+	// A child is restarted after InitialBackoff, which doubles for each of its
+	// recent restarts, up to MaxBackoff. Defaults to 10ms and 1s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// The default for ChildSpec.ShutdownTimeout. Defaults to 5s.
+	ShutdownTimeout time.Duration
+}
+
+// Returned by Supervisor.Run when it gave up restarting children.
+type RestartIntensityError struct {
+	Child string
+	Err   error
+}
+
+func (r *RestartIntensityError) Error() string {
+	return fmt.Sprintf("%s: child %q: %s", ErrRestartIntensityExceeded, r.Child, r.Err)
+}
+
+func (r *RestartIntensityError) Is(target error) bool {
+	return target == ErrRestartIntensityExceeded
+}
+
+func (r *RestartIntensityError) Unwrap() error {
+	return r.Err
+}
+
+// Returned (possibly within a *MultiError) when a child did not return within
+// its shutdown timeout. The child's Goroutine is abandoned.
+type ShutdownTimeoutError struct {
+	Child   string
+	Timeout time.Duration
+}
+
+func (s *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("child %q did not shut down within %s", s.Child, s.Timeout)
+}
+
+// The state of a single child at a point in time.
+type ChildStatus struct {
+	Name      string
+	Running   bool
+	Restarts  int
+	LastError error
+}
+
+// Runs a set of children and restarts them according to its strategy when
+// they fail.
+type Supervisor struct {
+	opts     SupervisorOptions
+	children []*supervisedChild
+
+	// Closed once Run has returned so that exiting children don't block
+	// trying to report back.
+	stoppedChan chan struct{}
+	exitChan    chan childExit
+
+	// Guards the status fields on each child.
+	mux sync.Mutex
+}
+
+type supervisedChild struct {
+	spec ChildSpec
+
+	// A generation number that increases every time the child is started so
+	// that we can ignore exits from instances we stopped on purpose.
+	generation int
+	cancel     context.CancelFunc
+	doneChan   chan struct{}
+	restarts   []time.Time
+
+	running   bool
+	total     int
+	lastError error
+}
+
+type childExit struct {
+	index      int
+	generation int
+	err        error
+}
+
+// Creates a new Supervisor for the given children. They're started in the
+// order given and stopped in the reverse order.
+func NewSupervisor(opts SupervisorOptions, children ...ChildSpec) *Supervisor {
+	if opts.MaxRestarts == 0 {
+		opts.MaxRestarts = 3
+	}
+
+	if opts.Period == 0 {
+		opts.Period = 5 * time.Second
+	}
+
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = 10 * time.Millisecond
+	}
+
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Second
+	}
+
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = 5 * time.Second
+	}
+
+	s := &Supervisor{
+		opts:        opts,
+		stoppedChan: make(chan struct{}),
+		exitChan:    make(chan childExit),
+	}
+
+	for _, spec := range children {
+		if spec.ShutdownTimeout == 0 {
+			spec.ShutdownTimeout = opts.ShutdownTimeout
+		}
+
+		s.children = append(s.children, &supervisedChild{spec: spec})
+	}
+
+	return s
+}
+
+// Starts every child and supervises them until the context is cancelled, at
+// which point the children are stopped in reverse order. Returns nil after a
+// clean shutdown, a *RestartIntensityError if children failed too often, and
+// *ShutdownTimeoutErrors for any children that overran their timeout. A
+// Supervisor can only be run once.
+func (s *Supervisor) Run(ctx context.Context) error {
+	defer close(s.stoppedChan)
+
+	for i := range s.children {
+		s.start(ctx, i)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return s.stop(s.allIndexes())
+		case exit := <-s.exitChan:
+			child := s.children[exit.index]
+			if exit.generation != child.generation {
+				// We stopped this instance ourselves as part of a restart.
+				continue
+			}
+
+			if err := s.handleExit(ctx, exit); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns the status of every child in the order they were given.
+func (s *Supervisor) Children() []ChildStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	statuses := make([]ChildStatus, len(s.children))
+	for i, child := range s.children {
+		statuses[i] = ChildStatus{
+			Name:      child.spec.Name,
+			Running:   child.running,
+			Restarts:  child.total,
+			LastError: child.lastError,
+		}
+	}
+
+	return statuses
+}
+
+func (s *Supervisor) handleExit(ctx context.Context, exit childExit) error {
+	child := s.children[exit.index]
+	err := exit.err
+	if err == nil {
+		err = ErrChildExited
+	}
+
+	s.mux.Lock()
+	child.running = false
+	child.lastError = err
+	s.mux.Unlock()
+
+	// Release the failed instance's context.
+	child.cancel()
+	child.cancel = nil
+
+	now := time.Now()
+	child.restarts = append(child.restarts, now)
+
+	if s.recentRestarts(now) > s.opts.MaxRestarts {
+		intensityErr := &RestartIntensityError{Child: child.spec.Name, Err: err}
+		if stopErr := s.stop(s.allIndexes()); stopErr != nil {
+			return NewMultiError(intensityErr, stopErr)
+		}
+
+		return intensityErr
+	}
+
+	affected := s.affectedBy(exit.index)
+
+	// The failed child has already exited, but its siblings need stopping
+	// before they can be restarted alongside it.
+	others := []int{}
+	for _, i := range affected {
+		if i != exit.index {
+			others = append(others, i)
+		}
+	}
+
+	// If a sibling won't stop, we can't safely start a second copy of it, so
+	// we give up on everything.
+	if err := s.stop(others); err != nil {
+		return NewMultiError(err, s.stop(s.allIndexes()))
+	}
+
+	timer := time.NewTimer(s.backoff(child))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		// Run will stop whatever is still running.
+		return nil
+	}
+
+	// Every affected child counts as restarted, not just the one that failed.
+	s.mux.Lock()
+	for _, i := range affected {
+		s.children[i].total++
+	}
+	s.mux.Unlock()
+
+	for _, i := range affected {
+		s.start(ctx, i)
+	}
+
+	return nil
+}
+
+// Returns the indexes of the children that need restarting, in start order.
+func (s *Supervisor) affectedBy(failed int) []int {
+	switch s.opts.Strategy {
+	case OneForAll:
+		return s.allIndexes()
+	case RestForOne:
+		indexes := []int{}
+		for i := failed; i < len(s.children); i++ {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	default:
+		return []int{failed}
+	}
+}
+
+func (s *Supervisor) allIndexes() []int {
+	indexes := make([]int, len(s.children))
+	for i := range s.children {
+		indexes[i] = i
+	}
+
+	return indexes
+}
+
+// Counts restarts across every child within the intensity period, dropping
+// any that have aged out.
+func (s *Supervisor) recentRestarts(now time.Time) int {
+	total := 0
+
+	for _, child := range s.children {
+		recent := child.restarts[:0]
+		for _, t := range child.restarts {
+			if now.Sub(t) <= s.opts.Period {
+				recent = append(recent, t)
+			}
+		}
+
+		child.restarts = recent
+		total += len(recent)
+	}
+
+	return total
+}
+
+func (s *Supervisor) backoff(child *supervisedChild) time.Duration {
+	delay := s.opts.InitialBackoff
+
+	for i := 1; i < len(child.restarts); i++ {
+		delay *= 2
+		if delay >= s.opts.MaxBackoff {
+			return s.opts.MaxBackoff
+		}
+	}
+
+	return delay
+}
+
+func (s *Supervisor) start(ctx context.Context, index int) {
+	child := s.children[index]
+	childCtx, cancel := context.WithCancel(ctx)
+
+	child.generation++
+	child.cancel = cancel
+	child.doneChan = make(chan struct{})
+
+	generation := child.generation
+	doneChan := child.doneChan
+
+	s.mux.Lock()
+	child.running = true
+	s.mux.Unlock()
+
+	go func() {
+		err := callRecovered(func() error {
+			return child.spec.Run(childCtx)
+		})
+
+		close(doneChan)
+
+		select {
+		case s.exitChan <- childExit{index: index, generation: generation, err: err}:
+		case <-s.stoppedChan:
+		}
+	}()
+}
+
+// Stops the given children in reverse order, waiting up to each one's
+// shutdown timeout.
+func (s *Supervisor) stop(indexes []int) error {
+	errs := []error{}
+
+	for i := len(indexes) - 1; i >= 0; i-- {
+		child := s.children[indexes[i]]
+		if child.cancel == nil {
+			continue
+		}
+
+		// Bumping the generation means we'll ignore this instance's exit.
+		child.generation++
+		child.cancel()
+		child.cancel = nil
+
+		timer := time.NewTimer(child.spec.ShutdownTimeout)
+		select {
+		case <-child.doneChan:
+		case <-timer.C:
+			errs = append(errs, &ShutdownTimeoutError{Child: child.spec.Name, Timeout: child.spec.ShutdownTimeout})
+		}
+		timer.Stop()
+
+		s.mux.Lock()
+		child.running = false
+		s.mux.Unlock()
+	}
+
+	return NewMultiError(errs...)
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Polls until cond is true, failing the test if it takes more than a second.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Counts how many times each child has been started.
+type startCounter struct {
+	mux    sync.Mutex
+	starts map[string]int
+}
+
+func (s *startCounter) start(name string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.starts == nil {
+		s.starts = map[string]int{}
+	}
+
+	s.starts[name]++
+	return s.starts[name]
+}
+
+func (s *startCounter) get(name string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.starts[name]
+}
+
+// Returns a child that runs until it's cancelled, except for the first time it
+// is started if failFirst is set.
+func countedChild(counter *startCounter, name string, failFirst bool) ChildSpec {
+	return ChildSpec{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			if counter.start(name) == 1 && failFirst {
+				return errDependency
+			}
+
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+}
+
+func runSupervisor(s *Supervisor) (context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- s.Run(ctx)
+	}()
+
+	return cancel, errChan
+}
+
+func TestSupervisorStrategies(t *testing.T) {
+	testCases := []struct {
+		strategy         RestartStrategy
+		expectedStarts   map[string]int
+		expectedRestarts map[string]int
+	}{
+		{
+			strategy:         OneForOne,
+			expectedStarts:   map[string]int{"a": 1, "b": 2, "c": 1},
+			expectedRestarts: map[string]int{"a": 0, "b": 1, "c": 0},
+		},
+		{
+			strategy:         OneForAll,
+			expectedStarts:   map[string]int{"a": 2, "b": 2, "c": 2},
+			expectedRestarts: map[string]int{"a": 1, "b": 1, "c": 1},
+		},
+		{
+			strategy:         RestForOne,
+			expectedStarts:   map[string]int{"a": 1, "b": 2, "c": 2},
+			expectedRestarts: map[string]int{"a": 0, "b": 1, "c": 1},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.strategy.String(), func(t *testing.T) {
+			counter := &startCounter{}
+
+			s := NewSupervisor(SupervisorOptions{Strategy: testCase.strategy, InitialBackoff: time.Millisecond},
+				countedChild(counter, "a", false),
+				countedChild(counter, "b", true),
+				countedChild(counter, "c", false),
+			)
+
+			cancel, errChan := runSupervisor(s)
+			defer cancel()
+
+			waitUntil(t, func() bool {
+				for _, status := range s.Children() {
+					if status.Restarts != testCase.expectedRestarts[status.Name] || !status.Running {
+						return false
+					}
+				}
+
+				return true
+			})
+
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatalf("expected a clean shutdown, got %s", err)
+			}
+
+			for name, expected := range testCase.expectedStarts {
+				if got := counter.get(name); got != expected {
+					t.Errorf("expected %s to be started %d times, got %d", name, expected, got)
+				}
+			}
+
+			for _, status := range s.Children() {
+				if status.Running {
+					t.Errorf("expected %s to be stopped", status.Name)
+				}
+
+				if status.Name == "b" && !errors.Is(status.LastError, errDependency) {
+					t.Errorf("expected b's last error to be %s, got %v", errDependency, status.LastError)
+				}
+			}
+		})
+	}
+}
+
+func TestSupervisorChildExitingCountsAsFailure(t *testing.T) {
+	counter := &startCounter{}
+
+	s := NewSupervisor(SupervisorOptions{InitialBackoff: time.Millisecond}, ChildSpec{
+		Name: "quitter",
+		Run: func(ctx context.Context) error {
+			if counter.start("quitter") == 1 {
+				return nil
+			}
+
+			<-ctx.Done()
+			return nil
+		},
+	})
+
+	cancel, errChan := runSupervisor(s)
+	defer cancel()
+
+	waitUntil(t, func() bool {
+		return counter.get("quitter") == 2
+	})
+
+	cancel()
+	<-errChan
+
+	status := s.Children()[0]
+	if status.Restarts != 1 || !errors.Is(status.LastError, ErrChildExited) {
+		t.Errorf("expected one restart after ErrChildExited, got %+v", status)
+	}
+}
+
+func TestSupervisorRestartIntensity(t *testing.T) {
+	counter := &startCounter{}
+
+	s := NewSupervisor(SupervisorOptions{MaxRestarts: 2, Period: time.Minute, InitialBackoff: time.Millisecond},
+		countedChild(counter, "steady", false),
+		ChildSpec{
+			Name: "flaky",
+			Run: func(ctx context.Context) error {
+				counter.start("flaky")
+				panic("boom")
+			},
+		},
+	)
+
+	_, errChan := runSupervisor(s)
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-time.After(time.Second):
+		t.Fatal("expected the supervisor to give up")
+	}
+
+	intensityErr := &RestartIntensityError{}
+	if !errors.Is(err, ErrRestartIntensityExceeded) || !errors.As(err, &intensityErr) {
+		t.Fatalf("expected a *RestartIntensityError, got %v", err)
+	}
+
+	panicErr := &PanicError{}
+	if intensityErr.Child != "flaky" || !errors.As(err, &panicErr) {
+		t.Errorf("expected flaky's panic to be reported, got %v", err)
+	}
+
+	// Started once, then restarted MaxRestarts times before the supervisor
+	// gave up.
+	if got := counter.get("flaky"); got != 3 {
+		t.Errorf("expected flaky to be started 3 times, got %d", got)
+	}
+
+	for _, status := range s.Children() {
+		if status.Running {
+			t.Errorf("expected %s to be stopped", status.Name)
+		}
+	}
+}
+
+func TestSupervisorShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	s := NewSupervisor(SupervisorOptions{ShutdownTimeout: 10 * time.Millisecond},
+		ChildSpec{
+			Name: "stubborn",
+			Run: func(ctx context.Context) error {
+				<-release
+				return nil
+			},
+		},
+	)
+
+	cancel, errChan := runSupervisor(s)
+	cancel()
+
+	err := <-errChan
+
+	timeoutErr := &ShutdownTimeoutError{}
+	if !errors.As(err, &timeoutErr) || timeoutErr.Child != "stubborn" || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Fatalf("expected a *ShutdownTimeoutError for stubborn, got %v", err)
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	s := NewSupervisor(SupervisorOptions{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+
+	testCases := []struct {
+		restarts int
+		expected time.Duration
+	}{
+		{restarts: 1, expected: 10 * time.Millisecond},
+		{restarts: 2, expected: 20 * time.Millisecond},
+		{restarts: 3, expected: 40 * time.Millisecond},
+		{restarts: 4, expected: 50 * time.Millisecond},
+		{restarts: 10, expected: 50 * time.Millisecond},
+	}
+
+	for _, testCase := range testCases {
+		child := &supervisedChild{restarts: make([]time.Time, testCase.restarts)}
+
+		if got := s.backoff(child); got != testCase.expected {
+			t.Errorf("expected %s after %d restarts, got %s", testCase.expected, testCase.restarts, got)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The registry that TimeIt and NamedSleep record into. It prints the same line
+// TimeIt has always printed so that the lessons keep their output.
+var DefaultTimingRegistry = NewTimingRegistry(os.Stdout)
+
+// A summary of every duration recorded under a single name.
+type TimingStats struct {
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	Total time.Duration `json:"total_ns"`
+	Min   time.Duration `json:"min_ns"`
+	Max   time.Duration `json:"max_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+}
+
+// How many samples the registry keeps per name for working out percentiles.
+// Count, Total, Min and Max are always exact.
+const TimingReservoirSize = 1024
+
+// Collects durations by name so that we can look at their distribution instead
+// of a single line per call. It is safe to use from multiple Goroutines.
+//
+// Memory use per name is bounded: once more than TimingReservoirSize
+// durations have been recorded, each new one replaces a random kept one
+// (reservoir sampling), so the kept samples stay an even sample of
+// everything recorded, and the percentiles are estimates from it.
+type TimingRegistry struct {
+	mux    sync.Mutex
+	out    io.Writer
+	series map[string]*timingSeries
+	rng    *rand.Rand
+}
+
+type timingSeries struct {
+	count   int
+	total   time.Duration
+	min     time.Duration
+	max     time.Duration
+	samples []time.Duration
+}
+
+// Creates a new TimingRegistry. If out is non-nil, each call to TimeIt will
+// also print a line to it; pass nil to only record.
+func NewTimingRegistry(out io.Writer) *TimingRegistry {
+	return &TimingRegistry{
+		out:    out,
+		series: map[string]*timingSeries{},
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Sets (or clears, with nil) where each TimeIt line gets printed.
+func (t *TimingRegistry) SetOutput(out io.Writer) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.out = out
+}
+
+// Times how long the given function takes to run and records it under name.
+func (t *TimingRegistry) TimeIt(name string, f func()) {
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		t.Record(name, elapsed)
+		t.println(fmt.Sprintf("%s finished running in Goroutine %d in %s", name, GetGoroutineID(), elapsed))
+	}()
+
+	f()
+}
+
+// Records a single duration under the given name.
+func (t *TimingRegistry) Record(name string, d time.Duration) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	series, ok := t.series[name]
+	if !ok {
+		series = &timingSeries{min: d, max: d}
+		t.series[name] = series
+	}
+
+	series.count++
+	series.total += d
+
+	if d < series.min {
+		series.min = d
+	}
+
+	if d > series.max {
+		series.max = d
+	}
+
+	if len(series.samples) < TimingReservoirSize {
+		series.samples = append(series.samples, d)
+		return
+	}
+
+	// Keep this one with probability TimingReservoirSize / count.
+	if i := t.rng.Intn(series.count); i < TimingReservoirSize {
+		series.samples[i] = d
+	}
+}
+
+// Returns the stats for a single name and whether anything was recorded for it.
+func (t *TimingRegistry) Stats(name string) (TimingStats, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	series, ok := t.series[name]
+	if !ok {
+		return TimingStats{}, false
+	}
+
+	return series.stats(name), true
+}
+
+// Returns the stats for every recorded name, sorted by name.
+func (t *TimingRegistry) Snapshot() []TimingStats {
+	t.mux.Lock()
+	stats := make([]TimingStats, 0, len(t.series))
+	for name, series := range t.series {
+		stats = append(stats, series.stats(name))
+	}
+	t.mux.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats
+}
+
+// Discards everything that has been recorded so far.
+func (t *TimingRegistry) Reset() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.series = map[string]*timingSeries{}
+}
+
+// Writes the current snapshot as a JSON array.
+func (t *TimingRegistry) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t.Snapshot())
+}
+
+// Writes the current snapshot in the Prometheus text exposition format. Each
+// name becomes a label on a single summary metric.
+// See: https://prometheus.io/docs/instrumenting/exposition_formats/
+func (t *TimingRegistry) WritePrometheus(w io.Writer) error {
+	const metric = "utils_timeit_duration_seconds"
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# HELP %s Time taken by functions wrapped in TimeIt.\n", metric)
+	fmt.Fprintf(b, "# TYPE %s summary\n", metric)
+
+	for _, stats := range t.Snapshot() {
+		label := escapePrometheusLabel(stats.Name)
+		quantiles := []struct {
+			q string
+			d time.Duration
+		}{
+			{"0.5", stats.P50},
+			{"0.9", stats.P90},
+			{"0.99", stats.P99},
+		}
+
+		for _, quantile := range quantiles {
+			fmt.Fprintf(b, "%s{name=\"%s\",quantile=\"%s\"} %g\n", metric, label, quantile.q, quantile.d.Seconds())
+		}
+
+		fmt.Fprintf(b, "%s_sum{name=\"%s\"} %g\n", metric, label, stats.Total.Seconds())
+		fmt.Fprintf(b, "%s_count{name=\"%s\"} %d\n", metric, label, stats.Count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t *TimingRegistry) println(line string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.out != nil {
+		fmt.Fprintln(t.out, line)
+	}
+}
+
+// Must be called with TimingRegistry.mux held.
+func (s *timingSeries) stats(name string) TimingStats {
+	sorted := append([]time.Duration{}, s.samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return TimingStats{
+		Name:  name,
+		Count: s.count,
+		Total: s.total,
+		Min:   s.min,
+		Max:   s.max,
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+	}
+}
+
+// Uses the nearest-rank method on an already sorted slice.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func escapePrometheusLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
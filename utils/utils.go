@@ -2,21 +2,15 @@ package utils
 
 import (
 	"fmt"
-	"math/rand"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 )
 
-// Accepts a name and a function and times how long it takes the function to run.
+// Accepts a name and a function and times how long it takes the function to
+// run. The duration is recorded in DefaultTimingRegistry, which also prints it.
 func TimeIt(name string, f func()) {
-	start := time.Now()
-	defer func() {
-		fmt.Printf("%s finished running in Goroutine %d in %s\n", name, GetGoroutineID(), time.Since(start))
-	}()
-
-	f()
+	DefaultTimingRegistry.TimeIt(name, f)
 }
 
 // Sleeps for a given time and outputs its name upon completion.
@@ -26,41 +20,31 @@ func NamedSleep(name string, d time.Duration) {
 	})
 }
 
-// Simple function that sums a slice of ints.
-func Sum(nums []int) int {
-	sum := 0
-
-	for _, num := range nums {
-		sum += num
-	}
-
-	return sum
-}
-
+// Returns a random int in [min, max] from DefaultRandomGenerator. Goroutines
+// that need a lot of random numbers should use their own
+// DefaultRandomGenerator.Fork() rather than contend over this one.
 func GenerateRandomNumber(min, max int) int {
-	return min + rand.Intn(max-min+1)
+	return DefaultRandomGenerator.Int(min, max)
 }
 
+// Returns n random ints in [min, max] from DefaultRandomGenerator.
 func GenerateRandomNumbers(min, max, n int) []int {
-	nums := make([]int, n)
-
-	for i := 0; i < n; i++ {
-		nums[i] = GenerateRandomNumber(min, max)
-	}
-
-	return nums
+	return DefaultRandomGenerator.Ints(min, max, n)
 }
 
 // Copied from https://gist.github.com/metafeather/3615b23097836bc36579100dac376906.
 // Generally, this isn't a good idea. See: https://go.dev/doc/faq#no_goroutine_id.
 // However, we'll do it here for purely educational purposes.
+//
+// We only need the header line of our own stack, which fits in 64 bytes, so
+// we parse that instead of capturing the whole thing.
 func GetGoroutineID() int {
 	var buf [64]byte
 	n := runtime.Stack(buf[:], false)
-	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
-	id, err := strconv.Atoi(idField)
+	header, _, _ := strings.Cut(string(buf[:n]), "\n")
+	g, err := parseGoroutineHeader(header)
 	if err != nil {
 		panic(fmt.Sprintf("cannot get goroutine id: %v", err))
 	}
-	return id
+	return g.ID
 }
@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configures a Watchdog.
+type WatchdogOptions struct {
+	// How long a watch may go without progress before it counts as stalled,
+	// unless one is given when registering it. Defaults to 10s.
+	StallTimeout time.Duration
+	// How often Run checks for stalls. Defaults to a quarter of StallTimeout,
+	// so watches with a much shorter timeout of their own should set this too.
+	CheckInterval time.Duration
+	// Where stall reports are written. Defaults to os.Stderr.
+	Output io.Writer
+	// Called with each stall report, after it has been written. Tests can use
+	// this to fail instead of hanging until the test binary times out.
+	OnStall func(report *StallReport)
+	// If set, called with a *StallError when a stall is found, e.g. to cancel
+	// the context the stuck work is running under.
+	Cancel CancelCauseFunc
+	// Defaults to SystemClock.
+	Clock Clock
+}
+
+// A watch that has gone without progress for longer than its timeout.
+type StalledWatch struct {
+	Name         string
+	LastProgress time.Time
+	Timeout      time.Duration
+}
+
+// Describes a stall: which watches stopped making progress, and what every
+// Goroutine was doing when we noticed.
+type StallReport struct {
+	At      time.Time
+	Stalled []StalledWatch
+	// Every Goroutine, grouped by state and stack, largest group first. Nil if
+	// the stacks could not be captured.
+	Goroutines []GoroutineGroup
+}
+
+// Formats the report like a stack dump, except that identical Goroutines are
+// only printed once and groups are arranged by what they are waiting on.
+func (s *StallReport) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "watchdog: %d watch(es) stalled:", len(s.Stalled))
+
+	for _, stalled := range s.Stalled {
+		fmt.Fprintf(b, "\n\t%q: no progress for %s (timeout %s)", stalled.Name, s.At.Sub(stalled.LastProgress), stalled.Timeout)
+	}
+
+	byState := map[string][]GoroutineGroup{}
+	states := []string{}
+	for _, group := range s.Goroutines {
+		if _, ok := byState[group.State]; !ok {
+			states = append(states, group.State)
+		}
+		byState[group.State] = append(byState[group.State], group)
+	}
+
+	sort.Strings(states)
+
+	for _, state := range states {
+		count := 0
+		for _, group := range byState[state] {
+			count += len(group.IDs)
+		}
+
+		fmt.Fprintf(b, "\n\n%s: %d goroutine(s)", state, count)
+
+		for _, group := range byState[state] {
+			fmt.Fprintf(b, "\n\n\t%d goroutine(s): %v", len(group.IDs), group.IDs)
+
+			for _, frame := range group.Stack {
+				fmt.Fprintf(b, "\n\t\t%s", frame)
+			}
+
+			if group.CreatedBy != nil {
+				fmt.Fprintf(b, "\n\t\tcreated by %s", group.CreatedBy)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// Passed to WatchdogOptions.Cancel when a stall is found.
+type StallError struct {
+	Report *StallReport
+}
+
+func (s *StallError) Error() string {
+	names := make([]string, len(s.Report.Stalled))
+	for i, stalled := range s.Report.Stalled {
+		names[i] = fmt.Sprintf("%q", stalled.Name)
+	}
+
+	return fmt.Sprintf("watchdog: no progress from %s", strings.Join(names, ", "))
+}
+
+// A single thing being watched. Call Beat whenever it makes progress and Stop
+// once it is finished.
+type Heartbeat struct {
+	watchdog *Watchdog
+	name     string
+	timeout  time.Duration
+
+	// These are guarded together so that Check can't pair a stale last with a
+	// fresh reported, or the other way around.
+	mux  sync.Mutex
+	last time.Time
+	// Set once a stall has been reported so that we only report it again
+	// after the next Beat.
+	reported bool
+}
+
+// Records that the watched thing has made progress.
+func (h *Heartbeat) Beat() {
+	now := h.watchdog.opts.Clock.Now()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.last = now
+	h.reported = false
+}
+
+// Marks the heartbeat as reported and returns when it last made progress, if
+// it has stalled and hasn't been reported yet.
+func (h *Heartbeat) checkStalled(now time.Time) (time.Time, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.reported || now.Sub(h.last) < h.timeout {
+		return time.Time{}, false
+	}
+
+	h.reported = true
+	return h.last, true
+}
+
+// Stops watching.
+func (h *Heartbeat) Stop() {
+	h.watchdog.mux.Lock()
+	defer h.watchdog.mux.Unlock()
+	delete(h.watchdog.watches, h)
+}
+
+// Notices when registered work stops making progress and dumps every
+// Goroutine's stack when it does. Without one, a forgotten close(destChan)
+// in nonBlockingChannelReads just hangs with nothing to go on.
+type Watchdog struct {
+	opts WatchdogOptions
+
+	mux     sync.Mutex
+	watches map[*Heartbeat]struct{}
+}
+
+// Creates a new Watchdog with nothing to watch. Call Run (or Check) to start
+// looking for stalls.
+func NewWatchdog(opts WatchdogOptions) *Watchdog {
+	if opts.StallTimeout <= 0 {
+		opts.StallTimeout = 10 * time.Second
+	}
+
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = opts.StallTimeout / 4
+	}
+
+	if opts.CheckInterval <= 0 {
+		// Only possible with a StallTimeout of a few nanoseconds.
+		opts.CheckInterval = opts.StallTimeout
+	}
+
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+
+	return &Watchdog{
+		opts:    opts,
+		watches: map[*Heartbeat]struct{}{},
+	}
+}
+
+// Starts watching something that should call Beat at least once every
+// timeout (or the default StallTimeout if timeout isn't positive). It counts
+// as having just made progress.
+func (w *Watchdog) Watch(name string, timeout time.Duration) *Heartbeat {
+	if timeout <= 0 {
+		timeout = w.opts.StallTimeout
+	}
+
+	h := &Heartbeat{watchdog: w, name: name, timeout: timeout, last: w.opts.Clock.Now()}
+
+	w.mux.Lock()
+	w.watches[h] = struct{}{}
+	w.mux.Unlock()
+
+	return h
+}
+
+// Runs fn, reporting a stall if it doesn't return within timeout (or the
+// default StallTimeout if timeout isn't positive). fn is not interrupted; use
+// WatchdogOptions.Cancel for that.
+func (w *Watchdog) Track(name string, timeout time.Duration, fn func() error) error {
+	h := w.Watch(name, timeout)
+	defer h.Stop()
+
+	return fn()
+}
+
+// Checks for stalls every CheckInterval until the context is cancelled.
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Checks for stalls once. If any watch has newly stalled, it writes a report,
+// calls OnStall and Cancel, and returns the report. Otherwise it returns nil.
+func (w *Watchdog) Check() *StallReport {
+	now := w.opts.Clock.Now()
+	report := &StallReport{At: now}
+
+	w.mux.Lock()
+	for h := range w.watches {
+		last, stalled := h.checkStalled(now)
+		if !stalled {
+			continue
+		}
+
+		report.Stalled = append(report.Stalled, StalledWatch{Name: h.name, LastProgress: last, Timeout: h.timeout})
+	}
+	w.mux.Unlock()
+
+	if len(report.Stalled) == 0 {
+		return nil
+	}
+
+	sort.Slice(report.Stalled, func(i, j int) bool {
+		return report.Stalled[i].Name < report.Stalled[j].Name
+	})
+
+	if goroutines, err := CaptureGoroutines(); err == nil {
+		report.Goroutines = GroupGoroutines(goroutines)
+	} else {
+		fmt.Fprintf(w.opts.Output, "watchdog: could not capture goroutines: %s\n", err)
+	}
+
+	fmt.Fprintf(w.opts.Output, "%s\n", report)
+
+	if w.opts.OnStall != nil {
+		w.opts.OnStall(report)
+	}
+
+	if w.opts.Cancel != nil {
+		w.opts.Cancel(&StallError{Report: report})
+	}
+
+	return report
+}
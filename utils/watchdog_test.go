@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWatchdogReportsStallsOnce(t *testing.T) {
+	clock := newFakeClock()
+	reports := 0
+	w := NewWatchdog(WatchdogOptions{
+		StallTimeout: time.Second,
+		Output:       io.Discard,
+		Clock:        clock,
+		OnStall: func(*StallReport) {
+			reports++
+		},
+	})
+
+	h := w.Watch("worker", 0)
+	other := w.Watch("other", time.Hour)
+	defer other.Stop()
+
+	clock.Advance(500 * time.Millisecond)
+	if report := w.Check(); report != nil {
+		t.Fatalf("expected no stall yet, got %s", report)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	report := w.Check()
+	if report == nil || len(report.Stalled) != 1 || report.Stalled[0].Name != "worker" {
+		t.Fatalf("expected worker to have stalled, got %v", report)
+	}
+
+	if len(report.Goroutines) == 0 {
+		t.Error("expected the report to include goroutines")
+	}
+
+	if w.Check() != nil {
+		t.Error("expected the same stall not to be reported twice")
+	}
+
+	// Progress rearms the watch, so the next stall is reported too.
+	h.Beat()
+	clock.Advance(time.Second)
+	if w.Check() == nil {
+		t.Error("expected a stall after the beat to be reported")
+	}
+
+	h.Stop()
+	clock.Advance(time.Second)
+	if w.Check() != nil {
+		t.Error("expected a stopped watch not to be reported")
+	}
+
+	if reports != 2 {
+		t.Errorf("expected OnStall to be called twice, got %d", reports)
+	}
+}
+
+func TestWatchdogCancels(t *testing.T) {
+	ctx, cancel := WithCancelCause(context.Background(), "service")
+	w := NewWatchdog(WatchdogOptions{StallTimeout: 20 * time.Millisecond, CheckInterval: 5 * time.Millisecond, Output: io.Discard, Cancel: cancel})
+
+	h := w.Watch("stuck", 0)
+	defer h.Stop()
+
+	go w.Run(ctx)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the watchdog to cancel the context")
+	}
+
+	stallErr := &StallError{}
+	if !errors.As(Cause(ctx), &stallErr) {
+		t.Fatalf("expected the cause to be a *StallError, got %v", Cause(ctx))
+	}
+}
+
+func TestWatchdogNegativeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// This used to panic with a negative ticker interval.
+	NewWatchdog(WatchdogOptions{StallTimeout: -time.Second, Output: io.Discard}).Run(ctx)
+}
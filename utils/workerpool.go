@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned by WorkerPool.Submit once the pool has been closed.
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+// The outcome of processing a single submitted item.
+type PoolResult[In, Out any] struct {
+	// The order in which the item was submitted, starting at zero. Calls to
+	// Submit that returned an error still use up an index.
+	Index int
+	Input In
+	Value Out
+	// The function's error, a *PanicError if it panicked, or the pool's
+	// context error if the item was abandoned.
+	Err error
+}
+
+// A point-in-time view of what a WorkerPool is doing.
+type PoolStats struct {
+	// Items waiting in the queue for a worker. Callers still blocked in Submit
+	// aren't counted.
+	Queued int64
+	// Items a worker is currently processing.
+	InFlight int64
+	// Items that were processed without an error.
+	Completed int64
+	// Items that returned an error or were abandoned due to cancellation.
+	Failed int64
+}
+
+type poolJob[In any] struct {
+	index int
+	item  In
+}
+
+// A fixed number of worker Goroutines pulling items from a bounded queue. This
+// is the producer / consumer pattern from the channels lesson, packaged up so
+// that it doesn't need to be wired together by hand each time.
+type WorkerPool[In, Out any] struct {
+	ctx   context.Context
+	fn    func(context.Context, In) (Out, error)
+	queue chan poolJob[In]
+	wg    sync.WaitGroup
+
+	// Guards closed. Submit holds the read lock while sending so that Close
+	// cannot close the queue out from under it.
+	mux    sync.RWMutex
+	closed bool
+
+	resultsMux sync.Mutex
+	results    []PoolResult[In, Out]
+
+	nextIndex atomic.Int64
+	inFlight  atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// Starts a pool of workers that call fn for each submitted item. At most
+// queueSize items can be waiting for a worker before Submit blocks. Once ctx
+// is cancelled, items still in the queue are not processed and are reported
+// as failed with the context's error.
+func NewWorkerPool[In, Out any](ctx context.Context, workers, queueSize int, fn func(context.Context, In) (Out, error)) *WorkerPool[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	w := &WorkerPool[In, Out]{
+		ctx:   ctx,
+		fn:    fn,
+		queue: make(chan poolJob[In], queueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.work()
+		}()
+	}
+
+	return w
+}
+
+// Adds an item to the queue, blocking until there's room for it. Returns an
+// error if either the given context or the pool's context ends first, or if
+// the pool has been closed.
+func (w *WorkerPool[In, Out]) Submit(ctx context.Context, item In) error {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+
+	if w.closed {
+		return ErrPoolClosed
+	}
+
+	job := poolJob[In]{
+		index: int(w.nextIndex.Add(1) - 1),
+		item:  item,
+	}
+
+	select {
+	case w.queue <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// Stops accepting new items. Items already in the queue are still processed.
+// It is safe to call more than once.
+func (w *WorkerPool[In, Out]) Close() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+// Closes the pool, waits for the workers to finish, and returns the result of
+// every submitted item in the order they were submitted.
+func (w *WorkerPool[In, Out]) Wait() []PoolResult[In, Out] {
+	w.Close()
+	w.wg.Wait()
+
+	w.resultsMux.Lock()
+	defer w.resultsMux.Unlock()
+
+	results := append([]PoolResult[In, Out]{}, w.results...)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+
+	return results
+}
+
+// Returns the current queue, in-flight, completed and failed counts.
+func (w *WorkerPool[In, Out]) Stats() PoolStats {
+	return PoolStats{
+		Queued:    int64(len(w.queue)),
+		InFlight:  w.inFlight.Load(),
+		Completed: w.completed.Load(),
+		Failed:    w.failed.Load(),
+	}
+}
+
+func (w *WorkerPool[In, Out]) work() {
+	for job := range w.queue {
+		result := PoolResult[In, Out]{Index: job.index, Input: job.item}
+
+		// We keep draining the queue after cancellation so that blocked callers
+		// of Submit are released and every item gets a result.
+		if err := w.ctx.Err(); err != nil {
+			result.Err = err
+		} else {
+			w.inFlight.Add(1)
+			result.Err = callRecovered(func() error {
+				var err error
+				result.Value, err = w.fn(w.ctx, job.item)
+				return err
+			})
+			w.inFlight.Add(-1)
+		}
+
+		if result.Err != nil {
+			w.failed.Add(1)
+		} else {
+			w.completed.Add(1)
+		}
+
+		w.resultsMux.Lock()
+		w.results = append(w.results, result)
+		w.resultsMux.Unlock()
+	}
+}